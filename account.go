@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/mail"
+)
+
+type accountConfig struct {
+	BaseURL          string
+	VerifyTokenTTL   time.Duration
+	ResetTokenTTL    time.Duration
+	UnverifiedLogin  bool
+	UnverifiedChirps bool
+	TOTPIssuer       string
+
+	EmailChangeTokenTTL     time.Duration
+	PasswordConfirmationTTL time.Duration
+	// AnonymizeDeletedChirps keeps the chirps of deleted users without an
+	// author instead of deleting them.
+	AnonymizeDeletedChirps bool
+	ExportDir              string
+	ExportTTL              time.Duration
+}
+
+func (cfg *apiConfig) sendVerificationEmail(user database.User) error {
+	token, err := auth.MakeActionToken(user.ID, cfg.JWTSecret, cfg.Account.VerifyTokenTTL, auth.TokenTypeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	return cfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email",
+		Body: fmt.Sprintf(
+			"Welcome to Chirpy!\n\nConfirm your email by opening:\n%s\n\nThe link expires in %s.\n",
+			cfg.Account.BaseURL+"/app/verify?token="+url.QueryEscape(token),
+			cfg.Account.VerifyTokenTTL,
+		),
+	})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(user database.User) error {
+	token, err := auth.MakeActionToken(user.ID, cfg.JWTSecret, cfg.Account.ResetTokenTTL, auth.TokenTypePasswordReset)
+	if err != nil {
+		return err
+	}
+	return cfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset your Chirpy password.\n\nChoose a new one at:\n%s\n\nThe link expires in %s. If this wasnt you, ignore this email.\n",
+			cfg.Account.BaseURL+"/app/reset-password?token="+url.QueryEscape(token),
+			cfg.Account.ResetTokenTTL,
+		),
+	})
+}
+
+// sendEmailChangeEmails asks the new address to confirm the change and lets
+// the old one know about it, so a hijacked session cant quietly take the
+// account over.
+func (cfg *apiConfig) sendEmailChangeEmails(user database.User, token string) error {
+	err := cfg.Mailer.Send(mail.Message{
+		To:      user.PendingEmail,
+		Subject: "Confirm your new Chirpy email",
+		Body: fmt.Sprintf(
+			"Someone asked to change the email of your Chirpy account to this address.\n\nConfirm the change by opening:\n%s\n\nThe link expires in %s. If this wasnt you, ignore this email.\n",
+			cfg.Account.BaseURL+"/app/confirm-email?token="+url.QueryEscape(token),
+			cfg.Account.EmailChangeTokenTTL,
+		),
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy email is being changed",
+		Body: fmt.Sprintf(
+			"Someone asked to change the email of your Chirpy account to %s. It changes once the new address is confirmed.\n\nIf this wasnt you, reset your password right away.\n",
+			user.PendingEmail,
+		),
+	})
+}
+
+// consumeActionToken validates a single-use token and marks it as used.
+func (cfg *apiConfig) consumeActionToken(w http.ResponseWriter, token string, tokenType auth.TokenType) (auth.ActionToken, bool) {
+	actionToken, err := auth.ValidateActionToken(token, cfg.JWTSecret, tokenType)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return auth.ActionToken{}, false
+	}
+	err = cfg.DB.ConsumeActionToken(actionToken.ID, actionToken.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenUsed) {
+			respondWithError(w, http.StatusBadRequest, "Token has already been used")
+			return auth.ActionToken{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt use token")
+		return auth.ActionToken{}, false
+	}
+	return actionToken, true
+}
+
+// confirmPassword checks the password, and second factor if the user has
+// one, of a user who is already signed in. Failures count towards the same
+// lockout as failed logins.
+func (cfg *apiConfig) confirmPassword(w http.ResponseWriter, r *http.Request, user database.User, password, code, recoveryCode string) bool {
+	accountKey := accountAttemptKey(user.Email)
+	ipKey := ipAttemptKey(clientIP(r))
+	wait, err := cfg.LoginLimiter.Attempt(accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return false
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait)
+		return false
+	}
+	err = cfg.Passwords.Verify(password, user.Password)
+	if err == nil && user.TOTPEnabled {
+		err = cfg.checkSecondFactor(user, code, recoveryCode)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Wrong password or code")
+		return false
+	}
+	if err := cfg.LoginLimiter.Succeeded(accountKey, ipKey); err != nil {
+		log.Printf("Couldnt reset failed logins: %s", err)
+	}
+	return true
+}
+
+func (cfg *apiConfig) handleUserVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	actionToken, ok := cfg.consumeActionToken(w, params.Token, auth.TokenTypeVerifyEmail)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.VerifyUser(actionToken.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt verify user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, newUser(user))
+}
+
+func (cfg *apiConfig) handleUserVerifyResend(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	if user.IsVerified {
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt send verification email")
+		return
+	}
+	respondWithJson(w, http.StatusAccepted, struct{}{})
+}
+
+func (cfg *apiConfig) handlePasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	// Always answer the same way so the endpoint cant be used to find
+	// out which emails have an account.
+	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if err == nil {
+		if err := cfg.sendPasswordResetEmail(user); err != nil {
+			log.Printf("Couldnt send password reset email: %s", err)
+		}
+	}
+	respondWithJson(w, http.StatusAccepted, struct{}{})
+}
+
+func (cfg *apiConfig) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	actionToken, err := auth.ValidateActionToken(params.Token, cfg.JWTSecret, auth.TokenTypePasswordReset)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+	user, err := cfg.DB.GetUser(actionToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	// The policy is checked before the token is consumed so a rejected
+	// password doesnt burn the reset link.
+	if !cfg.checkPasswordPolicy(w, params.Password, user.Email) {
+		return
+	}
+	if _, ok := cfg.consumeActionToken(w, params.Token, auth.TokenTypePasswordReset); !ok {
+		return
+	}
+	hashedPassword, err := cfg.Passwords.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt hash password")
+		return
+	}
+	err = cfg.DB.ResetUserPassword(user.ID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt reset password")
+		return
+	}
+	if err := cfg.LoginLimiter.Reset(accountAttemptKey(user.Email)); err != nil {
+		log.Printf("Couldnt reset failed logins: %s", err)
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/webhook"
+)
+
+func (cfg *apiConfig) handleAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	role, ok := database.ParseRole(params.Role)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown role")
+		return
+	}
+	if admin, ok := requestUser(r); ok && admin.ID == userID && role != database.RoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "You cant remove your own admin role")
+		return
+	}
+	user, err := cfg.DB.SetUserRole(userID, role)
+	if err != nil {
+		respondWithAdminUserError(w, err, "Couldnt update role")
+		return
+	}
+	cfg.audit(r, database.AuditUserRoleChanged, userID, map[string]string{"role": string(role)})
+	respondWithJson(w, http.StatusOK, newUser(user))
+}
+
+// adminUser is what admins see of a user.
+type adminUser struct {
+	User
+	VerifiedAt            *time.Time            `json:"verified_at,omitempty"`
+	TOTPEnabled           bool                  `json:"totp_enabled"`
+	Subscription          database.Subscription `json:"subscription"`
+	SuspendedAt           *time.Time            `json:"suspended_at,omitempty"`
+	SuspendedReason       string                `json:"suspended_reason,omitempty"`
+	PasswordResetRequired bool                  `json:"password_reset_required"`
+}
+
+func newAdminUser(user database.User) adminUser {
+	response := adminUser{
+		User:                  newOwnUser(user),
+		TOTPEnabled:           user.TOTPEnabled,
+		Subscription:          user.Subscription,
+		SuspendedAt:           user.SuspendedAt,
+		SuspendedReason:       user.SuspendedReason,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+	if !user.VerifiedAt.IsZero() {
+		response.VerifiedAt = &user.VerifiedAt
+	}
+	return response
+}
+
+// adminTarget parses the id of the user an admin route acts on. It writes
+// a 400 and returns false if it is malformed.
+func adminTarget(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return 0, false
+	}
+	return userID, true
+}
+
+// notSelf writes a 400 and returns false if the admin is acting on their
+// own account, which they would lock themselves out of.
+func notSelf(w http.ResponseWriter, r *http.Request, userID int, message string) bool {
+	if admin, ok := requestUser(r); ok && admin.ID == userID {
+		respondWithError(w, http.StatusBadRequest, message)
+		return false
+	}
+	return true
+}
+
+func respondWithAdminUserError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, message)
+}
+
+// handleAdminUsersRetrieve lists users, newest first. They can be searched
+// by part of their email with q and filtered by role and suspended.
+func (cfg *apiConfig) handleAdminUsersRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Users      []adminUser `json:"users"`
+		NextCursor int         `json:"next_cursor"`
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	query := database.UserQuery{Email: r.URL.Query().Get("q")}
+	if roleParam := r.URL.Query().Get("role"); roleParam != "" {
+		role, ok := database.ParseRole(roleParam)
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Unknown role")
+			return
+		}
+		query.Role = role
+	}
+	if suspendedParam := r.URL.Query().Get("suspended"); suspendedParam != "" {
+		suspended, err := strconv.ParseBool(suspendedParam)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldnt parse suspended")
+			return
+		}
+		query.Suspended = &suspended
+	}
+	dbUsers, err := cfg.DB.SearchUsers(query, cursor, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve users")
+		return
+	}
+	nextCursor := 0
+	if len(dbUsers) > limit {
+		dbUsers = dbUsers[:limit]
+		nextCursor = dbUsers[limit-1].ID
+	}
+	users := make([]adminUser, 0, len(dbUsers))
+	for _, user := range dbUsers {
+		users = append(users, newAdminUser(user))
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Users:      users,
+		NextCursor: nextCursor,
+	})
+}
+
+func (cfg *apiConfig) handleAdminUserRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithAdminUserError(w, err, "Couldnt retrieve user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, newAdminUser(user))
+}
+
+func (cfg *apiConfig) handleAdminUserSessionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	if _, err := cfg.DB.GetUser(userID); err != nil {
+		respondWithAdminUserError(w, err, "Couldnt retrieve sessions")
+		return
+	}
+	sessions, err := cfg.DB.GetSessions(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve sessions")
+		return
+	}
+	respondWithJson(w, http.StatusOK, sessions)
+}
+
+// handleAdminUserSuspend locks the user out of their account and ends
+// their sessions until they are unsuspended.
+func (cfg *apiConfig) handleAdminUserSuspend(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	if !notSelf(w, r, userID, "You cant suspend yourself") {
+		return
+	}
+	user, err := cfg.DB.SuspendUser(userID, strings.TrimSpace(params.Reason))
+	if err != nil {
+		respondWithAdminUserError(w, err, "Couldnt suspend user")
+		return
+	}
+	cfg.audit(r, database.AuditUserSuspended, userID, map[string]string{"reason": user.SuspendedReason})
+	respondWithJson(w, http.StatusOK, newAdminUser(user))
+}
+
+func (cfg *apiConfig) handleAdminUserUnsuspend(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.UnsuspendUser(userID)
+	if err != nil {
+		respondWithAdminUserError(w, err, "Couldnt unsuspend user")
+		return
+	}
+	cfg.audit(r, database.AuditUserUnsuspended, userID, nil)
+	respondWithJson(w, http.StatusOK, newAdminUser(user))
+}
+
+// handleAdminUserPasswordReset locks the user out and ends their sessions
+// until they choose a new password, and emails them a reset link.
+func (cfg *apiConfig) handleAdminUserPasswordReset(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	if !notSelf(w, r, userID, "Reset your own password through the forgot password flow") {
+		return
+	}
+	user, err := cfg.DB.RequirePasswordReset(userID)
+	if err != nil {
+		respondWithAdminUserError(w, err, "Couldnt require password reset")
+		return
+	}
+	cfg.audit(r, database.AuditUserPasswordReset, userID, nil)
+	if err := cfg.sendPasswordResetEmail(user); err != nil {
+		log.Printf("Couldnt send password reset email: %s", err)
+	}
+	respondWithJson(w, http.StatusOK, newAdminUser(user))
+}
+
+// handleAdminChirpyRedGrant makes the user a Chirpy Red member without a
+// payment, until period_end or, if it is left out, until it is revoked.
+func (cfg *apiConfig) handleAdminChirpyRedGrant(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PeriodEnd *time.Time `json:"period_end"`
+	}
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	periodEnd := time.Time{}
+	details := map[string]string{}
+	if params.PeriodEnd != nil {
+		if !params.PeriodEnd.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "period_end must be in the future")
+			return
+		}
+		periodEnd = params.PeriodEnd.UTC()
+		details["period_end"] = periodEnd.Format(time.RFC3339)
+	}
+	user, err := cfg.DB.GrantChirpyRed(userID, periodEnd)
+	if err != nil {
+		respondWithAdminUserError(w, err, "Couldnt grant Chirpy Red")
+		return
+	}
+	cfg.audit(r, database.AuditUserChirpyRedGranted, userID, details)
+	cfg.publishEvent(webhook.EventUserUpgraded, user.ID, newUser(user))
+	cfg.notify(database.Notification{
+		UserID: userID,
+		Type:   database.NotificationSubscription,
+		Detail: polkaEventUpgraded,
+	})
+	respondWithJson(w, http.StatusOK, newAdminUser(user))
+}
+
+func (cfg *apiConfig) handleAdminChirpyRedRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.RevokeChirpyRed(userID)
+	if err != nil {
+		respondWithAdminUserError(w, err, "Couldnt revoke Chirpy Red")
+		return
+	}
+	cfg.audit(r, database.AuditUserChirpyRedRevoked, userID, nil)
+	cfg.notify(database.Notification{
+		UserID: userID,
+		Type:   database.NotificationSubscription,
+		Detail: polkaEventDowngraded,
+	})
+	respondWithJson(w, http.StatusOK, newAdminUser(user))
+}
+
+// handleAdminUserDelete deletes the user like they could themselves. Admins
+// delete their own account through DELETE /api/users.
+func (cfg *apiConfig) handleAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+	if !notSelf(w, r, userID, "Delete your own account through /api/users") {
+		return
+	}
+	deleted, ok := cfg.deleteUser(w, userID)
+	if !ok {
+		return
+	}
+	cfg.audit(r, database.AuditUserDeleted, userID, map[string]string{
+		"chirps": strconv.Itoa(len(deleted.Chirps)),
+	})
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
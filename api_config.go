@@ -3,8 +3,17 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/thorbenbender/chirpy/internal/auth"
 	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/entitlements"
+	"github.com/thorbenbender/chirpy/internal/events"
+	"github.com/thorbenbender/chirpy/internal/mail"
+	"github.com/thorbenbender/chirpy/internal/oidc"
+	"github.com/thorbenbender/chirpy/internal/preview"
+	"github.com/thorbenbender/chirpy/internal/scheduler"
+	"github.com/thorbenbender/chirpy/internal/webhook"
 )
 
 type apiConfig struct {
@@ -12,6 +21,19 @@ type apiConfig struct {
 	DB             *database.DB
 	JWTSecret      string
 	ApiKey         string
+	Passwords      *auth.Passwords
+	PasswordPolicy *auth.PasswordPolicy
+	LoginLimiter   *loginLimiter
+	Mailer         mail.Mailer
+	Account        accountConfig
+	OIDCProviders  map[string]*oidc.Provider
+	Polka          polkaConfig
+	Entitlements   *entitlements.Store
+	ChirpLimiter   *userRateLimiter
+	Webhooks       *webhook.Dispatcher
+	Events         events.Broker
+	Scheduler      *scheduler.Scheduler
+	LinkPreviews   preview.Fetcher
 }
 
 func (cfg *apiConfig) handleMetrics(w http.ResponseWriter, r *http.Request) {
@@ -28,6 +50,7 @@ func (cfg *apiConfig) handleMetrics(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handleReset(w http.ResponseWriter, r *http.Request) {
+	cfg.audit(r, database.AuditMetricsReset, 0, map[string]string{"hits": strconv.Itoa(cfg.fileServerHits)})
 	cfg.fileServerHits = 0
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hits reset to 0"))
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// audit records an action the admin of the request took on targetUserID.
+// The action already happened, so failing to record it is only logged.
+func (cfg *apiConfig) audit(r *http.Request, action database.AuditAction, targetUserID int, details map[string]string) {
+	admin, _ := requestUser(r)
+	_, err := cfg.DB.RecordAudit(database.AuditEntry{
+		ActorID:      admin.ID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+		IP:           clientIP(r),
+	})
+	if err != nil {
+		log.Printf("Couldnt record audit entry %s for user %d: %s", action, targetUserID, err)
+	}
+}
+
+// handleAdminAuditLog lists admin actions, newest first, optionally only
+// those of actor_id, on target_user_id or of one action.
+func (cfg *apiConfig) handleAdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Entries    []database.AuditEntry `json:"entries"`
+		NextCursor int                   `json:"next_cursor"`
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	query := database.AuditQuery{Action: database.AuditAction(r.URL.Query().Get("action"))}
+	for param, field := range map[string]*int{
+		"actor_id":       &query.ActorID,
+		"target_user_id": &query.TargetUserID,
+	} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldnt parse "+param)
+			return
+		}
+		*field = id
+	}
+	entries, err := cfg.DB.GetAuditLog(query, cursor, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve audit log")
+		return
+	}
+	nextCursor := 0
+	if len(entries) > limit {
+		entries = entries[:limit]
+		nextCursor = entries[limit-1].ID
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Entries:    entries,
+		NextCursor: nextCursor,
+	})
+}
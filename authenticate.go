@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// accountRestriction returns why the user may not use their account right
+// now, or an empty string if they may.
+func accountRestriction(user database.User) string {
+	if user.SuspendedAt != nil {
+		return "Account is suspended"
+	}
+	if user.PasswordResetRequired {
+		return "Password reset required"
+	}
+	return ""
+}
+
+// checkAccount writes an error and returns false unless userID still
+// exists and may use their account.
+func (cfg *apiConfig) checkAccount(w http.ResponseWriter, userID int) bool {
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusUnauthorized, "User no longer exists")
+			return false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt find user")
+		return false
+	}
+	if restriction := accountRestriction(user); restriction != "" {
+		respondWithError(w, http.StatusForbidden, restriction)
+		return false
+	}
+	return true
+}
+
+// authenticate resolves the user id from the access JWT in the request.
+// Personal access tokens are not accepted. It writes a 401 and returns
+// false if that is not possible, including when the user was deleted
+// since the token was issued, and a 403 if the account is suspended or
+// needs a password reset.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request) (int, bool) {
+	token, err := auth.GetBearerToken(r.Header, "Bearer")
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt find jwt")
+		return 0, false
+	}
+	subject, err := auth.ValidateJWT(token, cfg.JWTSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt validate JWT")
+		return 0, false
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt parse id")
+		return 0, false
+	}
+	if !cfg.checkAccount(w, userID) {
+		return 0, false
+	}
+	return userID, true
+}
+
+// authorize is like authenticate but also accepts personal access tokens,
+// as long as they were granted scope. Access JWTs carry every scope.
+func (cfg *apiConfig) authorize(w http.ResponseWriter, r *http.Request, scope auth.Scope) (int, bool) {
+	token, err := auth.GetBearerToken(r.Header, "Bearer")
+	if err != nil || !auth.IsPersonalToken(token) {
+		return cfg.authenticate(w, r)
+	}
+	id, secret, ok := auth.ParsePersonalToken(token)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Malformed personal access token")
+		return 0, false
+	}
+	stored, err := cfg.DB.GetPersonalToken(id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid personal access token")
+		return 0, false
+	}
+	hash := auth.HashTokenSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(stored.SecretHash)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid personal access token")
+		return 0, false
+	}
+	if !stored.RevokedAt.IsZero() {
+		respondWithError(w, http.StatusUnauthorized, "Personal access token is revoked")
+		return 0, false
+	}
+	if !stored.ExpiresAt.IsZero() && time.Now().UTC().After(stored.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Personal access token is expired")
+		return 0, false
+	}
+	for _, granted := range stored.Scopes {
+		if auth.Scope(granted) != scope {
+			continue
+		}
+		if !cfg.checkAccount(w, stored.UserID) {
+			return 0, false
+		}
+		return stored.UserID, true
+	}
+	respondWithError(w, http.StatusForbidden, "Token is missing scope "+string(scope))
+	return 0, false
+}
+
+// viewer is for endpoints that anyone may call but that tailor the result
+// to a signed in user. It returns zero for anonymous requests; a token that
+// is sent must still be valid.
+func (cfg *apiConfig) viewer(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return 0, true
+	}
+	return cfg.authorize(w, r, auth.ScopeChirpsRead)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handleUserBlock(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	blockedID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	if blockedID == userID {
+		respondWithError(w, http.StatusBadRequest, "You cant block yourself")
+		return
+	}
+	block, err := cfg.DB.BlockUser(userID, blockedID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt block user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, block)
+}
+
+func (cfg *apiConfig) handleUserUnblock(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	blockedID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	err = cfg.DB.UnblockUser(userID, blockedID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt unblock user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+func (cfg *apiConfig) handleUserBlocksRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	blocks, err := cfg.DB.GetBlocks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve blocks")
+		return
+	}
+	respondWithJson(w, http.StatusOK, blocks)
+}
+
+func (cfg *apiConfig) handleUserMute(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	mutedID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	if mutedID == userID {
+		respondWithError(w, http.StatusBadRequest, "You cant mute yourself")
+		return
+	}
+	mute, err := cfg.DB.MuteUser(userID, mutedID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt mute user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, mute)
+}
+
+func (cfg *apiConfig) handleUserUnmute(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	mutedID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	err = cfg.DB.UnmuteUser(userID, mutedID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt unmute user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+func (cfg *apiConfig) handleUserMutesRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	mutes, err := cfg.DB.GetMutes(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve mutes")
+		return
+	}
+	respondWithJson(w, http.StatusOK, mutes)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+type Bookmark struct {
+	ID        int            `json:"id"`
+	Chirp     database.Chirp `json:"chirp"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+func (cfg *apiConfig) handleChirpBookmark(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	bookmark, err := cfg.DB.BookmarkChirp(userID, chirpID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt bookmark chirp")
+		return
+	}
+	respondWithJson(w, http.StatusOK, bookmark)
+}
+
+func (cfg *apiConfig) handleChirpUnbookmark(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	err = cfg.DB.UnbookmarkChirp(userID, chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt remove bookmark")
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+// handleBookmarksRetrieve pages through the users bookmarks, the most
+// recently saved first.
+func (cfg *apiConfig) handleBookmarksRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Bookmarks  []Bookmark `json:"bookmarks"`
+		NextCursor int        `json:"next_cursor"`
+	}
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	saved, err := cfg.DB.GetBookmarks(userID, cursor, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve bookmarks")
+		return
+	}
+	nextCursor := 0
+	if len(saved) > limit {
+		saved = saved[:limit]
+		nextCursor = saved[limit-1].Bookmark.ID
+	}
+	bookmarks := make([]Bookmark, 0, len(saved))
+	for _, bookmarked := range saved {
+		bookmarks = append(bookmarks, Bookmark{
+			ID:        bookmarked.Bookmark.ID,
+			Chirp:     bookmarked.Chirp,
+			CreatedAt: bookmarked.Bookmark.CreatedAt,
+		})
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Bookmarks:  bookmarks,
+		NextCursor: nextCursor,
+	})
+}
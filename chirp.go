@@ -2,22 +2,30 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/thorbenbender/chirpy/internal/auth"
 	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/entitlements"
+	"github.com/thorbenbender/chirpy/internal/webhook"
 )
 
 // region -- handlerChirpRetrieve
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
+	viewerID, ok := cfg.viewer(w, r)
+	if !ok {
+		return
+	}
 	authorIDString := r.URL.Query().Get("author_id")
 	chirps := []database.Chirp{}
 	if authorIDString == "" {
-		dbChirps, err := cfg.DB.GetChirps()
+		dbChirps, err := cfg.DB.GetChirps(viewerID)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Couldnt retrive chirps")
 			return
@@ -30,7 +38,7 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 			respondWithError(w, http.StatusBadRequest, "Couldnt parse author id")
 			return
 		}
-		dbChirps, err := cfg.DB.GetAuthorChirps(authorID)
+		dbChirps, err := cfg.DB.GetAuthorChirps(authorID, viewerID)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Couldnt get chirps")
 			return
@@ -59,44 +67,123 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 // region -- handlerChirpsCreate
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
-	}
-	token, err := auth.GetBearerToken(r.Header, "Bearer")
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "You are not logged in")
+		Body      string         `json:"body"`
+		ReplyToID int            `json:"reply_to_id"`
+		PublishAt *time.Time     `json:"publish_at"`
+		Poll      *database.Poll `json:"poll"`
 	}
-	subject, err := auth.ValidateJWT(token, cfg.JWTSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Wrong jwt format")
+	userIDInt, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
-	userIDInt, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldnt parse id")
+	userEntitlements, ok := cfg.allowChirp(w, userIDInt)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldnt decode parameters")
 		return
 	}
 
-	cleaned, err := validate_chirp(params.Body)
+	cleaned, err := validate_chirp(params.Body, userEntitlements.MaxChirpLength)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	chirp, err := cfg.DB.CreateChirp(cleaned, userIDInt)
+	chirp := database.Chirp{
+		Body:       cleaned,
+		AuthorID:   userIDInt,
+		ReplyToID:  params.ReplyToID,
+		MentionIDs: cfg.resolveMentions(cleaned, userIDInt),
+	}
+	if params.PublishAt != nil {
+		if !userEntitlements.CanScheduleChirps {
+			respondWithError(w, http.StatusForbidden, "Your plan cant schedule chirps")
+			return
+		}
+		if !validPublishAt(w, *params.PublishAt) {
+			return
+		}
+	}
+	if params.Poll != nil {
+		// A scheduled poll opens when its chirp is published.
+		opensAt := time.Now()
+		if params.PublishAt != nil {
+			opensAt = *params.PublishAt
+		}
+		chirp.Poll = validPoll(w, *params.Poll, opensAt)
+		if chirp.Poll == nil {
+			return
+		}
+	}
+	if !cfg.takeChirpQuota(w, userIDInt, userEntitlements) {
+		return
+	}
+	if params.PublishAt != nil {
+		chirp, err = cfg.DB.ScheduleChirp(chirp, *params.PublishAt)
+	} else {
+		chirp, err = cfg.DB.CreateChirp(chirp)
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldnt create chirp")
+		respondWithChirpError(w, err)
 		return
 	}
+	if !chirp.Scheduled {
+		cfg.announceChirp(chirp)
+	}
 	respondWithJson(w, http.StatusCreated, chirp)
 }
 
+// allowChirp checks that the user may chirp and returns what their plan
+// allows. It writes the error response and returns false if they may not.
+func (cfg *apiConfig) allowChirp(w http.ResponseWriter, userID int) (entitlements.Entitlements, bool) {
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt find user")
+		return entitlements.Entitlements{}, false
+	}
+	if !cfg.Account.UnverifiedChirps && !user.IsVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email before chirping")
+		return entitlements.Entitlements{}, false
+	}
+	return cfg.entitlementsFor(user), true
+}
+
+// takeChirpQuota counts a valid chirp against the users rate limit, so
+// requests that are rejected anyway dont use it up. It writes a 429 and
+// returns false if the limit is reached.
+func (cfg *apiConfig) takeChirpQuota(w http.ResponseWriter, userID int, userEntitlements entitlements.Entitlements) bool {
+	if ok, wait := cfg.ChirpLimiter.Allow(userID, userEntitlements.RateLimitPerMinute); !ok {
+		respondWithRateLimited(w, wait)
+		return false
+	}
+	return true
+}
+
+func respondWithChirpError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldnt find chirp to reply to")
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "You cant reply to or mention a user who blocked you")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldnt create chirp")
+}
+
+// announceChirp tells webhooks and the notified users about a chirp that
+// just became public and queues its link previews.
+func (cfg *apiConfig) announceChirp(chirp database.Chirp) {
+	cfg.publishEvent(webhook.EventChirpCreated, chirp.AuthorID, chirp)
+	cfg.notifyChirp(chirp)
+	cfg.queueLinkPreviews(chirp)
+}
+
 // endregion -- handlerChirpsCreate
 
 // region -- handlerChirpRetrieve
@@ -107,8 +194,12 @@ func (cfg *apiConfig) handlerChirpRetrieve(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, http.StatusBadRequest, "Id is in wrong format")
 		return
 	}
+	viewerID, ok := cfg.viewer(w, r)
+	if !ok {
+		return
+	}
 	chirp, err := cfg.DB.GetChirp(id)
-	if err != nil {
+	if err != nil || !chirp.VisibleTo(viewerID) {
 		respondWithError(w, http.StatusNotFound, "Couldnt retrieve chirp")
 		return
 	}
@@ -127,28 +218,22 @@ func (cfg *apiConfig) handlerChirpDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header, "Bearer")
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "JWT is in wrong format")
-		return
-	}
-	subject, err := auth.ValidateJWT(token, cfg.JWTSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldnt validate JWT")
-		return
-	}
-	userIDInt, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldnt parse user id")
+	userIDInt, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
 	dbChirp, err := cfg.DB.GetChirp(id)
-	if err != nil {
+	if err != nil || !dbChirp.VisibleTo(userIDInt) {
 		respondWithError(w, http.StatusNotFound, "Couldnt get chirp")
 		return
 	}
 
-	if dbChirp.AuthorID != userIDInt {
+	user, err := cfg.DB.GetUser(userIDInt)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt find user")
+		return
+	}
+	if !policyChirpDelete.allows(user, dbChirp.AuthorID) {
 		respondWithError(w, http.StatusForbidden, "You cant delete this chirp")
 		return
 	}
@@ -158,6 +243,9 @@ func (cfg *apiConfig) handlerChirpDelete(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldnt delete chirp")
 		return
 	}
+	if !dbChirp.Scheduled {
+		cfg.publishEvent(webhook.EventChirpDeleted, dbChirp.AuthorID, dbChirp)
+	}
 
 	respondWithJson(w, http.StatusOK, struct{}{})
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// runCommand runs the CLI subcommand named in args, if any, and reports
+// whether one was run.
+func runCommand(cfg *apiConfig, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "create-admin":
+		return true, cfg.commandCreateAdmin(args[1:])
+	}
+	return false, nil
+}
+
+// commandCreateAdmin bootstraps an admin account. An existing user with the
+// email is promoted instead. The password may also come from
+// CHIRPY_ADMIN_PASSWORD to keep it out of the shell history.
+func (cfg *apiConfig) commandCreateAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "Email of the admin")
+	password := flags.String("password", os.Getenv("CHIRPY_ADMIN_PASSWORD"), "Password of the admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("create-admin needs -email")
+	}
+
+	user, err := cfg.DB.GetUserByEmail(*email)
+	if errors.Is(err, database.ErrNotExist) {
+		violations, err := cfg.PasswordPolicy.Check(*password, *email)
+		if err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			return fmt.Errorf("password rejected: %s", violations[0].Message)
+		}
+		hashedPassword, err := cfg.Passwords.Hash(*password)
+		if err != nil {
+			return err
+		}
+		user, err = cfg.DB.CreateUser(*email, hashedPassword)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := cfg.DB.VerifyUser(user.ID); err != nil {
+		return err
+	}
+	if _, err := cfg.DB.SetUserRole(user.ID, database.RoleAdmin); err != nil {
+		return err
+	}
+	fmt.Printf("User %d (%s) is now an admin\n", user.ID, user.Email)
+	return nil
+}
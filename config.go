@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/mail"
+	"github.com/thorbenbender/chirpy/internal/netguard"
+	"github.com/thorbenbender/chirpy/internal/oidc"
+	"github.com/thorbenbender/chirpy/internal/preview"
+	"github.com/thorbenbender/chirpy/internal/scheduler"
+	"github.com/thorbenbender/chirpy/internal/webhook"
+)
+
+func envString(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func loadPasswords() (*auth.Passwords, error) {
+	bcryptHasher := auth.DefaultBcryptHasher()
+	bcryptHasher.Cost = envInt("BCRYPT_COST", bcryptHasher.Cost)
+
+	argonHasher := auth.DefaultArgon2idHasher()
+	argonHasher.Time = uint32(envInt("ARGON2_TIME", int(argonHasher.Time)))
+	argonHasher.Memory = uint32(envInt("ARGON2_MEMORY_KIB", int(argonHasher.Memory)))
+	argonHasher.Threads = uint8(envInt("ARGON2_THREADS", int(argonHasher.Threads)))
+
+	algorithm := auth.HashAlgorithm(envString("PASSWORD_HASH_ALGORITHM", string(auth.AlgorithmArgon2id)))
+	return auth.NewPasswords(algorithm, bcryptHasher, argonHasher)
+}
+
+func loadPasswordPolicy() (*auth.PasswordPolicy, error) {
+	banned, err := auth.LoadBannedPasswords(envString("PASSWORD_BANNED_FILE", "./config/common-passwords.txt"))
+	if err != nil {
+		return nil, err
+	}
+	policy := &auth.PasswordPolicy{
+		MinLength: envInt("PASSWORD_MIN_LENGTH", 8),
+		Banned:    banned,
+	}
+	// The breach check is on unless turned off explicitly, and a missing
+	// dataset stops startup rather than quietly letting every password
+	// through.
+	if envBool("PASSWORD_BREACH_CHECK", true) {
+		dir := envString("PASSWORD_BREACH_DIR", "./config/breached-passwords")
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("breached password dataset: %w (set PASSWORD_BREACH_CHECK=false to run without it)", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("breached password dataset %s is not a directory", dir)
+		}
+		policy.Breaches = auth.LocalBreachRange{Dir: dir}
+	}
+	return policy, nil
+}
+
+func envSeconds(key string, fallback time.Duration) time.Duration {
+	return time.Duration(envInt(key, int(fallback.Seconds()))) * time.Second
+}
+
+func loadLoginLimiter(db *database.DB) *loginLimiter {
+	return &loginLimiter{
+		DB:                 db,
+		MaxAccountFailures: envInt("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+		MaxIPFailures:      envInt("LOGIN_MAX_IP_FAILURES", 20),
+		BaseLockout:        envSeconds("LOGIN_LOCKOUT_BASE_SECONDS", 30*time.Second),
+		MaxLockout:         envSeconds("LOGIN_LOCKOUT_MAX_SECONDS", time.Hour),
+		FailureWindow:      envSeconds("LOGIN_FAILURE_WINDOW_SECONDS", 24*time.Hour),
+	}
+}
+
+func loadMailer() (mail.Mailer, error) {
+	from := envString("MAIL_FROM", "Chirpy <no-reply@chirpy.local>")
+	switch kind := envString("MAILER", "outbox"); kind {
+	case "smtp":
+		return mail.SMTPMailer{
+			Host:     envString("SMTP_HOST", "localhost"),
+			Port:     envInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "outbox":
+		return mail.NewOutboxMailer(envString("MAIL_OUTBOX_DIR", "./data/outbox"), from)
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
+
+func loadAccountConfig() accountConfig {
+	return accountConfig{
+		BaseURL:          envString("BASE_URL", "http://localhost:8080"),
+		VerifyTokenTTL:   envSeconds("VERIFY_TOKEN_TTL_SECONDS", 48*time.Hour),
+		ResetTokenTTL:    envSeconds("RESET_TOKEN_TTL_SECONDS", time.Hour),
+		UnverifiedLogin:  envBool("UNVERIFIED_CAN_LOGIN", true),
+		UnverifiedChirps: envBool("UNVERIFIED_CAN_CHIRP", false),
+		TOTPIssuer:       envString("TOTP_ISSUER", "Chirpy"),
+
+		EmailChangeTokenTTL:     envSeconds("EMAIL_CHANGE_TOKEN_TTL_SECONDS", 24*time.Hour),
+		PasswordConfirmationTTL: envSeconds("PASSWORD_CONFIRMATION_TTL_SECONDS", 10*time.Minute),
+		AnonymizeDeletedChirps:  envString("DELETED_USER_CHIRPS", "delete") == "anonymize",
+		ExportDir:               envString("EXPORT_DIR", "./data/exports"),
+		ExportTTL:               envSeconds("EXPORT_TTL_SECONDS", 24*time.Hour),
+	}
+}
+
+// loadOIDCProviders reads providers listed in OIDC_PROVIDERS, each set up
+// through OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and
+// OIDC_<NAME>_CLIENT_SECRET. With OIDC_FAKE_PROVIDER=true an in-process fake
+// provider is also returned, to be mounted at /oidc/fake. The fake provider
+// hands out a verified identity for any email, so it is refused unless
+// BASE_URL is on this machine.
+func loadOIDCProviders(baseURL string) (map[string]*oidc.Provider, *oidc.FakeProvider, error) {
+	providers := map[string]*oidc.Provider{}
+	redirectURL := func(name string) string {
+		return baseURL + "/api/auth/" + name + "/callback"
+	}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			return nil, nil, fmt.Errorf("oidc provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		providers[name] = oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  redirectURL(name),
+		})
+	}
+
+	if !envBool("OIDC_FAKE_PROVIDER", false) {
+		return providers, nil, nil
+	}
+	if !isLoopbackURL(baseURL) {
+		return nil, nil, fmt.Errorf("OIDC_FAKE_PROVIDER lets anyone log in as any user and needs a localhost BASE_URL, not %s", baseURL)
+	}
+	log.Printf("OIDC_FAKE_PROVIDER is enabled: anyone can log in as any user through /api/auth/fake/login")
+	fake, err := oidc.NewFakeProvider(baseURL+"/oidc/fake", "chirpy")
+	if err != nil {
+		return nil, nil, err
+	}
+	providers["fake"] = oidc.NewProvider(oidc.Config{
+		Name:        "fake",
+		Issuer:      fake.Issuer,
+		ClientID:    fake.ClientID,
+		RedirectURL: redirectURL("fake"),
+	})
+	return providers, fake, nil
+}
+
+// isLoopbackURL reports whether rawURL points at localhost or a loopback
+// address.
+func isLoopbackURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+func loadPolkaConfig() polkaConfig {
+	return polkaConfig{
+		SigningSecret:      os.Getenv("POLKA_WEBHOOK_SECRET"),
+		SignatureTolerance: envSeconds("POLKA_SIGNATURE_TOLERANCE_SECONDS", 5*time.Minute),
+		BillingPeriod:      envSeconds("CHIRPY_RED_PERIOD_SECONDS", 30*24*time.Hour),
+		GracePeriod:        envSeconds("CHIRPY_RED_GRACE_SECONDS", 7*24*time.Hour),
+		DeliveryLogSize:    envInt("WEBHOOK_DELIVERY_LOG_SIZE", 1000),
+	}
+}
+
+func loadWebhookDispatcher(db *database.DB) *webhook.Dispatcher {
+	client := netguard.NewClient(
+		envSeconds("WEBHOOK_TIMEOUT_SECONDS", 10*time.Second),
+		envBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+	)
+	dispatcher := webhook.NewDispatcher(db, client)
+	dispatcher.MaxAttempts = envInt("WEBHOOK_MAX_ATTEMPTS", dispatcher.MaxAttempts)
+	dispatcher.BaseBackoff = envSeconds("WEBHOOK_BASE_BACKOFF_SECONDS", dispatcher.BaseBackoff)
+	dispatcher.MaxBackoff = envSeconds("WEBHOOK_MAX_BACKOFF_SECONDS", dispatcher.MaxBackoff)
+	return dispatcher
+}
+
+func loadScheduler(db *database.DB) *scheduler.Scheduler {
+	jobs := scheduler.New(db)
+	jobs.PollInterval = envSeconds("SCHEDULER_POLL_INTERVAL_SECONDS", jobs.PollInterval)
+	jobs.MaxAttempts = envInt("SCHEDULER_MAX_ATTEMPTS", jobs.MaxAttempts)
+	return jobs
+}
+
+// loadLinkPreviews returns nil, turning previews off, if
+// LINK_PREVIEWS_ENABLED is false.
+func loadLinkPreviews() preview.Fetcher {
+	if !envBool("LINK_PREVIEWS_ENABLED", true) {
+		return nil
+	}
+	fetcher := preview.NewHTTPFetcher(netguard.NewClient(
+		envSeconds("LINK_PREVIEW_TIMEOUT_SECONDS", 5*time.Second),
+		envBool("LINK_PREVIEW_ALLOW_PRIVATE_TARGETS", false),
+	))
+	fetcher.MaxBytes = int64(envInt("LINK_PREVIEW_MAX_BYTES", int(fetcher.MaxBytes)))
+	return fetcher
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestIsLoopbackURL(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "http://localhost:8080", expected: true},
+		{input: "http://LOCALHOST", expected: true},
+		{input: "http://127.0.0.1:8080", expected: true},
+		{input: "http://[::1]:8080", expected: true},
+		{input: "https://chirpy.example.com", expected: false},
+		{input: "http://localhost.example.com", expected: false},
+		{input: "http://10.0.0.1", expected: false},
+		{input: "://bad", expected: false},
+	}
+
+	for _, cas := range cases {
+		if actual := isLoopbackURL(cas.input); actual != cas.expected {
+			t.Errorf("%s should be loopback %v not %v", cas.input, cas.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+const (
+	// maxConversationParticipants includes the creator.
+	maxConversationParticipants = 8
+	maxMessageLength            = 1000
+)
+
+type Conversation struct {
+	ID             int         `json:"id"`
+	ParticipantIDs []int       `json:"participant_ids"`
+	CreatedBy      int         `json:"created_by"`
+	CreatedAt      time.Time   `json:"created_at"`
+	LastMessageAt  time.Time   `json:"last_message_at"`
+	ReadUpTo       map[int]int `json:"read_up_to"`
+	UnreadCount    int         `json:"unread_count"`
+}
+
+func newConversation(conversation database.Conversation, unread int) Conversation {
+	readUpTo := map[int]int{}
+	for _, id := range conversation.ParticipantIDs {
+		readUpTo[id] = conversation.ReadUpTo[id]
+	}
+	return Conversation{
+		ID:             conversation.ID,
+		ParticipantIDs: conversation.ParticipantIDs,
+		CreatedBy:      conversation.CreatedBy,
+		CreatedAt:      conversation.CreatedAt,
+		LastMessageAt:  conversation.LastMessageAt,
+		ReadUpTo:       readUpTo,
+		UnreadCount:    unread,
+	}
+}
+
+// Message carries its read receipts: the other participants who have read
+// it.
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+	ReadBy         []int     `json:"read_by"`
+}
+
+func newMessage(message database.Message, conversation database.Conversation) Message {
+	readBy := []int{}
+	for _, id := range conversation.ParticipantIDs {
+		if id != message.SenderID && conversation.ReadUpTo[id] >= message.ID {
+			readBy = append(readBy, id)
+		}
+	}
+	sort.Ints(readBy)
+	return Message{
+		ID:             message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Body:           message.Body,
+		CreatedAt:      message.CreatedAt,
+		ReadBy:         readBy,
+	}
+}
+
+func (cfg *apiConfig) handleConversationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ParticipantIDs []int `json:"participant_ids"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	others := map[int]struct{}{}
+	for _, id := range params.ParticipantIDs {
+		if id != userID {
+			others[id] = struct{}{}
+		}
+	}
+	if len(others) == 0 {
+		respondWithError(w, http.StatusBadRequest, "A conversation needs at least one other participant")
+		return
+	}
+	if len(others)+1 > maxConversationParticipants {
+		respondWithError(w, http.StatusBadRequest, "Conversations can have at most "+strconv.Itoa(maxConversationParticipants)+" participants")
+		return
+	}
+
+	conversation, err := cfg.DB.CreateConversation(userID, params.ParticipantIDs)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find user")
+			return
+		}
+		if errors.Is(err, database.ErrBlocked) {
+			respondWithError(w, http.StatusForbidden, "You cant message this user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt create conversation")
+		return
+	}
+	respondWithJson(w, http.StatusCreated, newConversation(conversation, 0))
+}
+
+func (cfg *apiConfig) handleConversationsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversations, unread, err := cfg.DB.GetConversations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve conversations")
+		return
+	}
+	response := make([]Conversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		response = append(response, newConversation(conversation, unread[conversation.ID]))
+	}
+	respondWithJson(w, http.StatusOK, response)
+}
+
+// conversationForUser loads the conversation named in the URL. Anyone but a
+// participant gets a 404, so conversations cannot be probed for.
+func (cfg *apiConfig) conversationForUser(w http.ResponseWriter, r *http.Request, userID int) (database.Conversation, bool) {
+	conversationID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return database.Conversation{}, false
+	}
+	conversation, err := cfg.DB.GetConversation(conversationID, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find conversation")
+			return database.Conversation{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve conversation")
+		return database.Conversation{}, false
+	}
+	return conversation, true
+}
+
+func (cfg *apiConfig) handleConversationRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, ok := cfg.conversationForUser(w, r, userID)
+	if !ok {
+		return
+	}
+	_, unread, err := cfg.DB.GetConversations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve conversation")
+		return
+	}
+	respondWithJson(w, http.StatusOK, newConversation(conversation, unread[conversation.ID]))
+}
+
+// handleMessagesRetrieve pages through a conversation newest first, in the
+// same way as notifications.
+func (cfg *apiConfig) handleMessagesRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Messages   []Message `json:"messages"`
+		NextCursor int       `json:"next_cursor"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, ok := cfg.conversationForUser(w, r, userID)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	messages, err := cfg.DB.GetMessages(conversation.ID, userID, cursor, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve messages")
+		return
+	}
+	nextCursor := 0
+	if len(messages) > limit {
+		messages = messages[:limit]
+		nextCursor = messages[limit-1].ID
+	}
+	result := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, newMessage(message, conversation))
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Messages:   result,
+		NextCursor: nextCursor,
+	})
+}
+
+func (cfg *apiConfig) handleMessageCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, ok := cfg.conversationForUser(w, r, userID)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	if params.Body == "" {
+		respondWithError(w, http.StatusBadRequest, "Message is empty")
+		return
+	}
+	cleaned, err := validate_chirp(params.Body, maxMessageLength)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message, conversation, err := cfg.DB.CreateMessage(conversation.ID, userID, cleaned)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find conversation")
+			return
+		}
+		if errors.Is(err, database.ErrBlocked) {
+			respondWithError(w, http.StatusForbidden, "You cant message this conversation")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt send message")
+		return
+	}
+	respondWithJson(w, http.StatusCreated, newMessage(message, conversation))
+}
+
+// handleConversationRead moves the users read receipt up to message_id, or
+// to the newest message if it is left out.
+func (cfg *apiConfig) handleConversationRead(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MessageID int `json:"message_id"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, ok := cfg.conversationForUser(w, r, userID)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+			return
+		}
+	}
+	conversation, err := cfg.DB.MarkConversationRead(conversation.ID, userID, params.MessageID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt mark conversation read")
+		return
+	}
+	_, unread, err := cfg.DB.GetConversations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve conversation")
+		return
+	}
+	respondWithJson(w, http.StatusOK, newConversation(conversation, unread[conversation.ID]))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// maxDraftSize only keeps drafts from growing without bound. The chirp
+// rules are applied when a draft is published.
+const maxDraftSize = 4096
+
+type draftParameters struct {
+	Body      string `json:"body"`
+	ReplyToID int    `json:"reply_to_id"`
+}
+
+func decodeDraft(w http.ResponseWriter, r *http.Request) (draftParameters, bool) {
+	params := draftParameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return draftParameters{}, false
+	}
+	if len(params.Body) > maxDraftSize {
+		respondWithError(w, http.StatusBadRequest, "Draft is too long")
+		return draftParameters{}, false
+	}
+	return params, true
+}
+
+func draftID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return 0, false
+	}
+	return id, true
+}
+
+func respondWithDraftError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldnt find draft")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldnt update draft")
+}
+
+func (cfg *apiConfig) handleDraftCreate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt find user")
+		return
+	}
+	params, ok := decodeDraft(w, r)
+	if !ok {
+		return
+	}
+	draft, err := cfg.DB.CreateDraft(database.Draft{
+		AuthorID:  userID,
+		Body:      params.Body,
+		ReplyToID: params.ReplyToID,
+	}, cfg.entitlementsFor(user).MaxDrafts)
+	if err != nil {
+		if errors.Is(err, database.ErrDraftLimit) {
+			respondWithError(w, http.StatusForbidden, "Your plan cant have more drafts")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt create draft")
+		return
+	}
+	respondWithJson(w, http.StatusCreated, draft)
+}
+
+func (cfg *apiConfig) handleDraftsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+	drafts, err := cfg.DB.GetDrafts(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve drafts")
+		return
+	}
+	respondWithJson(w, http.StatusOK, drafts)
+}
+
+func (cfg *apiConfig) handleDraftRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+	id, ok := draftID(w, r)
+	if !ok {
+		return
+	}
+	draft, err := cfg.DB.GetDraft(userID, id)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, draft)
+}
+
+func (cfg *apiConfig) handleDraftUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	id, ok := draftID(w, r)
+	if !ok {
+		return
+	}
+	params, ok := decodeDraft(w, r)
+	if !ok {
+		return
+	}
+	draft, err := cfg.DB.UpdateDraft(userID, id, params.Body, params.ReplyToID)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, draft)
+}
+
+func (cfg *apiConfig) handleDraftDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	id, ok := draftID(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.DB.DeleteDraft(userID, id)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+// handleDraftPublish validates the draft like a new chirp and replaces it
+// with that chirp.
+func (cfg *apiConfig) handleDraftPublish(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	id, ok := draftID(w, r)
+	if !ok {
+		return
+	}
+	draft, err := cfg.DB.GetDraft(userID, id)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	userEntitlements, ok := cfg.allowChirp(w, userID)
+	if !ok {
+		return
+	}
+	cleaned, err := validate_chirp(draft.Body, userEntitlements.MaxChirpLength)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !cfg.takeChirpQuota(w, userID, userEntitlements) {
+		return
+	}
+	chirp, err := cfg.DB.PublishDraft(userID, draft.ID, draft.UpdatedAt, database.Chirp{
+		Body:       cleaned,
+		AuthorID:   userID,
+		ReplyToID:  draft.ReplyToID,
+		MentionIDs: cfg.resolveMentions(cleaned, userID),
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrDraftChanged) {
+			respondWithError(w, http.StatusConflict, "Draft was changed while publishing, try again")
+			return
+		}
+		if errors.Is(err, database.ErrDraftNotExist) {
+			respondWithDraftError(w, err)
+			return
+		}
+		respondWithChirpError(w, err)
+		return
+	}
+	cfg.announceChirp(chirp)
+	respondWithJson(w, http.StatusCreated, chirp)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/entitlements"
+)
+
+func (cfg *apiConfig) entitlementsFor(user database.User) entitlements.Entitlements {
+	return cfg.Entitlements.For(string(user.UserPlan()))
+}
+
+func (cfg *apiConfig) handleEntitlementsRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Plan         database.Plan             `json:"plan"`
+		Entitlements entitlements.Entitlements `json:"entitlements"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Plan:         user.UserPlan(),
+		Entitlements: cfg.entitlementsFor(user),
+	})
+}
+
+// reloadOnHangup rereads the entitlements file on SIGHUP so product can
+// change plans without a restart.
+func reloadOnHangup(store *entitlements.Store) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := store.Reload(); err != nil {
+				log.Printf("Couldnt reload entitlements: %s", err)
+				continue
+			}
+			log.Printf("Reloaded entitlements")
+		}
+	}()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+type exportProfile struct {
+	User
+	VerifiedAt              time.Time                          `json:"verified_at"`
+	TwoFactorEnabled        bool                               `json:"two_factor_enabled"`
+	Subscription            database.Subscription              `json:"subscription"`
+	NotificationPreferences map[database.NotificationType]bool `json:"notification_preferences"`
+}
+
+// handleUserExport hands out the users data export. The first call queues
+// it and answers 202; once it is written the next call downloads it, and
+// only that one: the call after queues a fresh export.
+func (cfg *apiConfig) handleUserExport(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Status    database.ExportStatus `json:"status"`
+		CreatedAt time.Time             `json:"created_at"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	export, err := cfg.DB.TakeExport(userID, time.Now().UTC())
+	if err == nil {
+		cfg.serveExport(w, export)
+		return
+	}
+	if !errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve export")
+		return
+	}
+	export, err = cfg.DB.RequestExport(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt start export")
+		return
+	}
+	cfg.Scheduler.Wake()
+	w.Header().Set("Retry-After", "5")
+	respondWithJson(w, http.StatusAccepted, response{
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+	})
+}
+
+// serveExport sends the export file and removes it afterwards.
+func (cfg *apiConfig) serveExport(w http.ResponseWriter, export database.Export) {
+	defer func() {
+		if err := os.Remove(export.File); err != nil {
+			log.Printf("Couldnt remove export %s: %s", export.File, err)
+		}
+	}()
+	file, err := os.Open(export.File)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt read export")
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Couldnt send export %d: %s", export.ID, err)
+	}
+}
+
+// exportUserData writes the export job.SubjectID into Account.ExportDir.
+func (cfg *apiConfig) exportUserData(ctx context.Context, job database.Job) error {
+	export, err := cfg.DB.GetExport(job.SubjectID)
+	if errors.Is(err, database.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if export.Status == database.ExportReady {
+		return nil
+	}
+	data, err := cfg.DB.GetUserData(export.UserID)
+	if errors.Is(err, database.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(cfg.Account.ExportDir, 0o700)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	path := filepath.Join(cfg.Account.ExportDir, fmt.Sprintf("export-%d-%s.zip", export.ID, hex.EncodeToString(suffix)))
+	err = writeExport(path, data)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	err = cfg.DB.CompleteExport(export.ID, path, time.Now().UTC().Add(cfg.Account.ExportTTL))
+	if err != nil {
+		os.Remove(path)
+		if errors.Is(err, database.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func writeExport(path string, data database.UserData) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	personalTokens := make([]PersonalToken, 0, len(data.PersonalTokens))
+	for _, token := range data.PersonalTokens {
+		personalTokens = append(personalTokens, newPersonalToken(token))
+	}
+	entries := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", exportProfile{
+			User:                    newUser(data.User),
+			VerifiedAt:              data.User.VerifiedAt,
+			TwoFactorEnabled:        data.User.TOTPEnabled,
+			Subscription:            data.User.Subscription,
+			NotificationPreferences: data.User.NotificationPreferences,
+		}},
+		{"chirps.json", data.Chirps},
+		{"drafts.json", data.Drafts},
+		{"bookmarks.json", data.Bookmarks},
+		{"poll_votes.json", data.PollVotes},
+		{"lists.json", data.Lists},
+		{"follows.json", data.Follows},
+		{"blocks.json", data.Blocks},
+		{"mutes.json", data.Mutes},
+		{"messages.json", data.Messages},
+		{"sessions.json", data.Sessions},
+		{"personal_tokens.json", personalTokens},
+		{"linked_accounts.json", data.Identities},
+	}
+	now := time.Now()
+	archive := zip.NewWriter(file)
+	for _, entry := range entries {
+		writer, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entry.data); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
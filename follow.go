@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handleUserFollow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	followeeID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	if followeeID == userID {
+		respondWithError(w, http.StatusBadRequest, "You cant follow yourself")
+		return
+	}
+	follow, created, err := cfg.DB.FollowUser(userID, followeeID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find user")
+			return
+		}
+		if errors.Is(err, database.ErrBlocked) {
+			respondWithError(w, http.StatusForbidden, "You cant follow this user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt follow user")
+		return
+	}
+	if created {
+		cfg.notify(database.Notification{
+			UserID:  followeeID,
+			Type:    database.NotificationFollow,
+			ActorID: userID,
+		})
+	}
+	respondWithJson(w, http.StatusOK, follow)
+}
+
+func (cfg *apiConfig) handleUserUnfollow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	followeeID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	err = cfg.DB.UnfollowUser(userID, followeeID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt unfollow user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
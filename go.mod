@@ -3,8 +3,11 @@ module github.com/thorbenbender/chirpy
 go 1.22.0
 
 require (
-	github.com/go-chi/chi/v5 v5.0.12 // indirect
-	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.20.0 // indirect
+	github.com/go-chi/chi/v5 v5.0.12
+	github.com/golang-jwt/jwt/v5 v5.2.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.20.0
 )
+
+require golang.org/x/sys v0.17.0 // indirect
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,7 +11,6 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type TokenType string
@@ -17,18 +18,21 @@ type TokenType string
 const (
 	TokenTypeAccess  TokenType = "chirpy-access"
 	TokenTypeRefresh TokenType = "chirpy-refresh"
-)
 
-func HashPassword(password string) (string, error) {
-	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
-	if err != nil {
-		return "", err
-	}
-	return string(dat), nil
-}
+	TokenTypeVerifyEmail   TokenType = "chirpy-verify-email"
+	TokenTypePasswordReset TokenType = "chirpy-password-reset"
+	TokenTypeMFAChallenge  TokenType = "chirpy-mfa-challenge"
+
+	TokenTypePasswordConfirmation TokenType = "chirpy-password-confirmation"
+	TokenTypeChangeEmail          TokenType = "chirpy-change-email"
+)
 
-func CheckPassword(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// ActionToken is a signed, expiring token for a single action such as
+// verifying an email. ID lets the caller make it single-use.
+type ActionToken struct {
+	ID        string
+	UserID    int
+	ExpiresAt time.Time
 }
 
 func MakeJWT(
@@ -49,6 +53,12 @@ func MakeJWT(
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (string, error) {
+	return ValidateTokenType(tokenString, tokenSecret, TokenTypeAccess)
+}
+
+// ValidateTokenType validates a JWT made by MakeJWT and returns its subject
+// if it was issued as tokenType.
+func ValidateTokenType(tokenString, tokenSecret string, tokenType TokenType) (string, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
@@ -68,48 +78,130 @@ func ValidateJWT(tokenString, tokenSecret string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(tokenType) {
 		return "", errors.New("invalid issuer")
 	}
 	return userIDString, nil
 }
 
-func RefreshToken(tokenString, tokenSecret string) (string, error) {
-	claimStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
+// ValidateAccessToken validates an access JWT and returns its user id and
+// expiry, for long-lived connections that must end when the token does.
+func ValidateAccessToken(tokenString, tokenSecret string) (int, time.Time, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(
 		tokenString,
-		&claimStruct,
+		&claims,
 		func(t *jwt.Token) (interface{}, error) {
 			return []byte(tokenSecret), nil
 		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(string(TokenTypeAccess)),
+		jwt.WithExpirationRequired(),
 	)
 	if err != nil {
-		return "", err
+		return 0, time.Time{}, err
 	}
-
-	userIDString, err := token.Claims.GetSubject()
+	userID, err := strconv.Atoi(claims.Subject)
 	if err != nil {
-		return "", nil
+		return 0, time.Time{}, err
 	}
+	return userID, claims.ExpiresAt.Time, nil
+}
 
-	issuer, err := token.Claims.GetIssuer()
-	if err != nil {
+func MakeActionToken(
+	userID int,
+	tokenSecret string,
+	expiresIn time.Duration,
+	tokenType TokenType,
+) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
 		return "", err
 	}
-	if issuer != string(TokenTypeRefresh) {
-		return "", errors.New("invalid issuer")
-	}
+	now := time.Now().UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ID:        hex.EncodeToString(id),
+		Issuer:    string(tokenType),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		Subject:   fmt.Sprintf("%d", userID),
+	})
+	return token.SignedString([]byte(tokenSecret))
+}
 
-	userID, err := strconv.Atoi(userIDString)
+func ValidateActionToken(tokenString, tokenSecret string, tokenType TokenType) (ActionToken, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return []byte(tokenSecret), nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(string(tokenType)),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
+		return ActionToken{}, err
+	}
+	if claims.ID == "" {
+		return ActionToken{}, errors.New("missing token id")
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return ActionToken{}, err
+	}
+	return ActionToken{
+		ID:        claims.ID,
+		UserID:    userID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// GenerateSessionID returns a random id for a login session.
+func GenerateSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(id), nil
+}
 
-	newToken, err := MakeJWT(userID, tokenSecret, time.Hour, TokenTypeAccess)
+// MakeRefreshToken makes a refresh JWT that belongs to the login session
+// sessionID, so ending the session revokes the token.
+func MakeRefreshToken(userID int, sessionID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ID:        sessionID,
+		Issuer:    string(TokenTypeRefresh),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		Subject:   fmt.Sprintf("%d", userID),
+	})
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// ValidateRefreshToken returns the user and session of a refresh JWT.
+// Tokens issued before sessions were stored have no session id.
+func ValidateRefreshToken(tokenString, tokenSecret string) (userID int, sessionID string, err error) {
+	claims := jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return []byte(tokenSecret), nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(string(TokenTypeRefresh)),
+	)
 	if err != nil {
-		return "", err
+		return 0, "", err
+	}
+	userID, err = strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, "", err
 	}
-	return newToken, nil
+	return userID, claims.ID, nil
 }
 
 func GetBearerToken(headers http.Header, authToken string) (string, error) {
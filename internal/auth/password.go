@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type HashAlgorithm string
+
+const (
+	AlgorithmBcrypt   HashAlgorithm = "bcrypt"
+	AlgorithmArgon2id HashAlgorithm = "argon2id"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password does not match")
+	ErrUnknownHash      = errors.New("unknown password hash format")
+)
+
+// PasswordHasher hashes passwords into self-describing strings, so the
+// algorithm and its parameters can be recovered from the stored hash.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, hash string) error
+	// NeedsRehash reports whether hash was produced with a different
+	// algorithm or weaker parameters than the hasher is configured for.
+	NeedsRehash(hash string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	dat, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(dat), nil
+}
+
+func (h BcryptHasher) Verify(password, hash string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	if hashAlgorithm(hash) != AlgorithmBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost < h.Cost
+}
+
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Memory,
+		h.Time,
+		h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Verify(password, hash string) error {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey(
+		[]byte(password),
+		params.salt,
+		params.time,
+		params.memory,
+		params.threads,
+		uint32(len(params.key)),
+	)
+	if subtle.ConstantTimeCompare(key, params.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.time < h.Time ||
+		params.memory < h.Memory ||
+		params.threads < h.Threads ||
+		uint32(len(params.key)) < h.KeyLen ||
+		uint32(len(params.salt)) < h.SaltLen
+}
+
+func parseArgon2id(hash string) (argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != string(AlgorithmArgon2id) {
+		return argon2Params{}, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return argon2Params{}, ErrUnknownHash
+	}
+	if version != argon2.Version {
+		return argon2Params{}, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	params := argon2Params{}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return argon2Params{}, ErrUnknownHash
+	}
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, ErrUnknownHash
+	}
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2Params{}, ErrUnknownHash
+	}
+	return params, nil
+}
+
+func hashAlgorithm(hash string) HashAlgorithm {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(hash, "$2a$"),
+		strings.HasPrefix(hash, "$2b$"),
+		strings.HasPrefix(hash, "$2y$"):
+		return AlgorithmBcrypt
+	}
+	return ""
+}
+
+// Passwords hashes new passwords with Preferred and verifies stored hashes
+// with whichever algorithm produced them.
+type Passwords struct {
+	Preferred PasswordHasher
+	Bcrypt    BcryptHasher
+	Argon2id  Argon2idHasher
+}
+
+func NewPasswords(algorithm HashAlgorithm, bcryptHasher BcryptHasher, argonHasher Argon2idHasher) (*Passwords, error) {
+	p := &Passwords{
+		Bcrypt:   bcryptHasher,
+		Argon2id: argonHasher,
+	}
+	switch algorithm {
+	case AlgorithmBcrypt:
+		if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("invalid bcrypt cost %d", bcryptHasher.Cost)
+		}
+		p.Preferred = bcryptHasher
+	case AlgorithmArgon2id:
+		if argonHasher.Time == 0 || argonHasher.Memory == 0 || argonHasher.Threads == 0 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+		p.Preferred = argonHasher
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+	return p, nil
+}
+
+func DefaultBcryptHasher() BcryptHasher {
+	return BcryptHasher{Cost: 12}
+}
+
+func DefaultArgon2idHasher() Argon2idHasher {
+	return Argon2idHasher{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func (p *Passwords) Hash(password string) (string, error) {
+	return p.Preferred.Hash(password)
+}
+
+func (p *Passwords) Verify(password, hash string) error {
+	switch hashAlgorithm(hash) {
+	case AlgorithmArgon2id:
+		return p.Argon2id.Verify(password, hash)
+	case AlgorithmBcrypt:
+		return p.Bcrypt.Verify(password, hash)
+	}
+	return ErrUnknownHash
+}
+
+func (p *Passwords) NeedsRehash(hash string) bool {
+	return p.Preferred.NeedsRehash(hash)
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type Scope string
+
+const (
+	ScopeChirpsRead   Scope = "chirps:read"
+	ScopeChirpsWrite  Scope = "chirps:write"
+	ScopeProfileWrite Scope = "profile:write"
+)
+
+var knownScopes = map[Scope]struct{}{
+	ScopeChirpsRead:   {},
+	ScopeChirpsWrite:  {},
+	ScopeProfileWrite: {},
+}
+
+// PersonalTokenPrefix marks personal access tokens so they can be told
+// apart from JWTs in the Authorization header.
+const PersonalTokenPrefix = "chirpy_pat_"
+
+func ParseScopes(raw []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(raw))
+	seen := map[Scope]struct{}{}
+	for _, value := range raw {
+		scope := Scope(value)
+		if _, ok := knownScopes[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope %q", value)
+		}
+		if _, ok := seen[scope]; ok {
+			continue
+		}
+		seen[scope] = struct{}{}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// GeneratePersonalToken returns a new token of the form
+// chirpy_pat_<id>_<secret>. Only the id and a hash of the secret should be
+// stored.
+func GeneratePersonalToken() (id, secret, token string, err error) {
+	rawID := make([]byte, 8)
+	if _, err := rand.Read(rawID); err != nil {
+		return "", "", "", err
+	}
+	rawSecret := make([]byte, 32)
+	if _, err := rand.Read(rawSecret); err != nil {
+		return "", "", "", err
+	}
+	id = hex.EncodeToString(rawID)
+	secret = hex.EncodeToString(rawSecret)
+	return id, secret, PersonalTokenPrefix + id + "_" + secret, nil
+}
+
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+func ParsePersonalToken(token string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(token, PersonalTokenPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, found = strings.Cut(rest, "_")
+	if !found || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+func HashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
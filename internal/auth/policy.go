@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+type PolicyRule string
+
+const (
+	RuleMinLength      PolicyRule = "min_length"
+	RuleCommonPassword PolicyRule = "common_password"
+	RuleSimilarToEmail PolicyRule = "similar_to_email"
+	RuleBreached       PolicyRule = "breached"
+)
+
+type PolicyViolation struct {
+	Rule    PolicyRule `json:"rule"`
+	Message string     `json:"message"`
+}
+
+// BreachRangeSource returns the SHA-1 suffixes known for a five character
+// hash prefix, so the full hash of a password never leaves the checker.
+type BreachRangeSource interface {
+	Range(prefix string) (map[string]struct{}, error)
+}
+
+// LocalBreachRange reads ranges from a directory holding one file per
+// uppercase hex prefix, each line being "SUFFIX" or "SUFFIX:COUNT".
+type LocalBreachRange struct {
+	Dir string
+}
+
+func (l LocalBreachRange) Range(prefix string) (map[string]struct{}, error) {
+	suffixes := map[string]struct{}{}
+	file, err := os.Open(filepath.Join(l.Dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return suffixes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if suffix == "" {
+			continue
+		}
+		suffixes[strings.ToUpper(suffix)] = struct{}{}
+	}
+	return suffixes, scanner.Err()
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	Banned    map[string]struct{}
+	Breaches  BreachRangeSource
+}
+
+func LoadBannedPasswords(path string) (map[string]struct{}, error) {
+	banned := map[string]struct{}{}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		banned[strings.ToLower(line)] = struct{}{}
+	}
+	return banned, scanner.Err()
+}
+
+// Check returns every rule the password fails. An error is only returned
+// when a rule could not be evaluated.
+func (p *PasswordPolicy) Check(password, email string) ([]PolicyViolation, error) {
+	violations := []PolicyViolation{}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	lowered := strings.ToLower(password)
+	if _, ok := p.Banned[lowered]; ok {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleCommonPassword,
+			Message: "Password is too common",
+		})
+	}
+	if similarToEmail(lowered, strings.ToLower(email)) {
+		violations = append(violations, PolicyViolation{
+			Rule:    RuleSimilarToEmail,
+			Message: "Password is too similar to the email",
+		})
+	}
+	if p.Breaches != nil && password != "" {
+		breached, err := isBreached(p.Breaches, password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, PolicyViolation{
+				Rule:    RuleBreached,
+				Message: "Password has appeared in a data breach",
+			})
+		}
+	}
+	return violations, nil
+}
+
+func similarToEmail(password, email string) bool {
+	if password == "" || email == "" {
+		return false
+	}
+	if password == email {
+		return true
+	}
+	local, domain, _ := strings.Cut(email, "@")
+	domainName, _, _ := strings.Cut(domain, ".")
+	for _, part := range []string{local, domainName} {
+		if len(part) < 3 {
+			continue
+		}
+		if strings.Contains(password, part) || strings.Contains(part, password) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBreached(source BreachRangeSource, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := source.Range(digest[:5])
+	if err != nil {
+		return false, err
+	}
+	_, ok := suffixes[digest[5:]]
+	return ok, nil
+}
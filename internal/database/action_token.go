@@ -0,0 +1,28 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrTokenUsed = errors.New("Token has already been used")
+
+// ConsumeActionToken marks a single-use token as used. It returns
+// ErrTokenUsed if the token was consumed before.
+// The check and the mark happen in one update, so of two concurrent
+// requests with the same token only one succeeds.
+func (db *DB) ConsumeActionToken(id string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.UsedActionTokens[id]; ok {
+			return ErrTokenUsed
+		}
+		now := time.Now().UTC()
+		for usedID, usedExpiry := range dbStructure.UsedActionTokens {
+			if usedExpiry.Before(now) {
+				delete(dbStructure.UsedActionTokens, usedID)
+			}
+		}
+		dbStructure.UsedActionTokens[id] = expiresAt
+		return nil
+	})
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// UserQuery filters the users listed to admins. Zero fields match every
+// user.
+type UserQuery struct {
+	// Email matches users whose email contains it, ignoring case.
+	Email string
+	Role  Role
+	// Suspended matches only suspended users if true, and only users who
+	// arent if false.
+	Suspended *bool
+}
+
+func (query UserQuery) matches(user User) bool {
+	if query.Email != "" && !strings.Contains(emailKey(user.Email), emailKey(query.Email)) {
+		return false
+	}
+	if query.Role != "" && user.UserRole() != query.Role {
+		return false
+	}
+	if query.Suspended != nil && *query.Suspended != (user.SuspendedAt != nil) {
+		return false
+	}
+	return true
+}
+
+// SearchUsers returns up to limit users matching query with an id below
+// before, newest first. Before zero starts at the newest user.
+func (db *DB) SearchUsers(query UserQuery, before, limit int) ([]User, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	users := []User{}
+	for _, user := range dbStructure.Users {
+		if before > 0 && user.ID >= before {
+			continue
+		}
+		if query.matches(user) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID > users[j].ID
+	})
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// endSessions deletes the users sessions, so none of their refresh tokens
+// work anymore.
+func (dbStructure *DBStructure) endSessions(userID int) {
+	for id, session := range dbStructure.Sessions {
+		if session.UserID == userID {
+			delete(dbStructure.Sessions, id)
+		}
+	}
+}
+
+// SuspendUser locks the user out and ends their sessions. Suspending a
+// suspended user only updates the reason.
+func (db *DB) SuspendUser(userID int, reason string) (User, error) {
+	return db.updateUserStructure(userID, func(dbStructure *DBStructure, user *User) error {
+		if user.SuspendedAt == nil {
+			now := time.Now().UTC()
+			user.SuspendedAt = &now
+		}
+		user.SuspendedReason = reason
+		dbStructure.endSessions(userID)
+		return nil
+	})
+}
+
+func (db *DB) UnsuspendUser(userID int) (User, error) {
+	return db.updateUser(userID, func(user *User) error {
+		user.SuspendedAt = nil
+		user.SuspendedReason = ""
+		return nil
+	})
+}
+
+// RequirePasswordReset locks the user out and ends their sessions until
+// they choose a new password through ResetUserPassword.
+func (db *DB) RequirePasswordReset(userID int) (User, error) {
+	return db.updateUserStructure(userID, func(dbStructure *DBStructure, user *User) error {
+		user.PasswordResetRequired = true
+		dbStructure.endSessions(userID)
+		return nil
+	})
+}
+
+// ResetUserPassword is UpdateUserPassword for a password reset, which also
+// lifts a reset required by an admin and ends the users sessions, so
+// whoever knew the old password is logged out.
+func (db *DB) ResetUserPassword(userID int, password string) error {
+	_, err := db.updateUserStructure(userID, func(dbStructure *DBStructure, user *User) error {
+		user.Password = password
+		user.PasswordResetRequired = false
+		dbStructure.endSessions(userID)
+		return nil
+	})
+	return err
+}
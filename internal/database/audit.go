@@ -0,0 +1,103 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditUserRoleChanged      AuditAction = "user.role_changed"
+	AuditUserUnlocked         AuditAction = "user.unlocked"
+	AuditUserSuspended        AuditAction = "user.suspended"
+	AuditUserUnsuspended      AuditAction = "user.unsuspended"
+	AuditUserPasswordReset    AuditAction = "user.password_reset_required"
+	AuditUserChirpyRedGranted AuditAction = "user.chirpy_red_granted"
+	AuditUserChirpyRedRevoked AuditAction = "user.chirpy_red_revoked"
+	AuditUserDeleted          AuditAction = "user.deleted"
+	AuditWebhookCreated       AuditAction = "webhook.created"
+	AuditWebhookDeleted       AuditAction = "webhook.deleted"
+	AuditWebhookRetried       AuditAction = "webhook.delivery_retried"
+	AuditMetricsReset         AuditAction = "metrics.reset"
+)
+
+// AuditEntry records an action an admin took. Entries are kept when the
+// actor or target is deleted.
+type AuditEntry struct {
+	ID           int               `json:"id"`
+	ActorID      int               `json:"actor_id"`
+	Action       AuditAction       `json:"action"`
+	TargetUserID int               `json:"target_user_id"`
+	Details      map[string]string `json:"details,omitempty"`
+	IP           string            `json:"ip"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// AuditQuery filters the audit log. Zero fields match every entry.
+type AuditQuery struct {
+	ActorID      int
+	TargetUserID int
+	Action       AuditAction
+}
+
+func (query AuditQuery) matches(entry AuditEntry) bool {
+	if query.ActorID != 0 && entry.ActorID != query.ActorID {
+		return false
+	}
+	if query.TargetUserID != 0 && entry.TargetUserID != query.TargetUserID {
+		return false
+	}
+	if query.Action != "" && entry.Action != query.Action {
+		return false
+	}
+	return true
+}
+
+func (dbStructure *DBStructure) nextAuditEntryID() int {
+	id := 0
+	for existing := range dbStructure.AuditLog {
+		if existing > id {
+			id = existing
+		}
+	}
+	return id + 1
+}
+
+func (db *DB) RecordAudit(entry AuditEntry) (AuditEntry, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		entry.ID = dbStructure.nextAuditEntryID()
+		entry.CreatedAt = time.Now().UTC()
+		dbStructure.AuditLog[entry.ID] = entry
+		return nil
+	})
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	return entry, nil
+}
+
+// GetAuditLog returns up to limit entries matching query with an id below
+// before, newest first. Before zero starts at the newest entry.
+func (db *DB) GetAuditLog(query AuditQuery, before, limit int) ([]AuditEntry, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	entries := []AuditEntry{}
+	for _, entry := range dbStructure.AuditLog {
+		if before > 0 && entry.ID >= before {
+			continue
+		}
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID > entries[j].ID
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
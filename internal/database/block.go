@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrBlocked = errors.New("User is blocked")
+
+type Block struct {
+	BlockerID int       `json:"blocker_id"`
+	BlockedID int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) BlockUser(blockerID, blockedID int) (Block, error) {
+	block := Block{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[blockedID]; !ok {
+			return ErrNotExist
+		}
+		key := followKey(blockerID, blockedID)
+		if existing, ok := dbStructure.Blocks[key]; ok {
+			block = existing
+			return nil
+		}
+		block = Block{
+			BlockerID: blockerID,
+			BlockedID: blockedID,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Blocks[key] = block
+		// A block ends the relationship in both directions.
+		delete(dbStructure.Follows, followKey(blockerID, blockedID))
+		delete(dbStructure.Follows, followKey(blockedID, blockerID))
+		return nil
+	})
+	if err != nil {
+		return Block{}, err
+	}
+	return block, nil
+}
+
+func (db *DB) UnblockUser(blockerID, blockedID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Blocks, followKey(blockerID, blockedID))
+		return nil
+	})
+}
+
+// GetBlocks returns the blocks blockerID has made.
+func (db *DB) GetBlocks(blockerID int) ([]Block, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	blocks := []Block{}
+	for _, block := range dbStructure.Blocks {
+		if block.BlockerID == blockerID {
+			blocks = append(blocks, block)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].CreatedAt.Before(blocks[j].CreatedAt)
+	})
+	return blocks, nil
+}
+
+func (dbStructure *DBStructure) blocks(blockerID, blockedID int) bool {
+	_, ok := dbStructure.Blocks[followKey(blockerID, blockedID)]
+	return ok
+}
+
+// hiddenAuthors returns the users viewerID blocked or muted. Their chirps
+// and the notifications they cause are left out of everything viewerID
+// lists. A zero viewerID is anonymous and hides nobody.
+func (dbStructure *DBStructure) hiddenAuthors(viewerID int) map[int]struct{} {
+	hidden := map[int]struct{}{}
+	if viewerID == 0 {
+		return hidden
+	}
+	for _, block := range dbStructure.Blocks {
+		if block.BlockerID == viewerID {
+			hidden[block.BlockedID] = struct{}{}
+		}
+	}
+	for _, mute := range dbStructure.Mutes {
+		if mute.MuterID == viewerID {
+			hidden[mute.MutedID] = struct{}{}
+		}
+	}
+	return hidden
+}
+
+// GetHiddenAuthors is for feeds that are pushed rather than listed, so they
+// can filter the same way listings do.
+func (db *DB) GetHiddenAuthors(viewerID int) (map[int]struct{}, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return dbStructure.hiddenAuthors(viewerID), nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Bookmark saves a chirp for a user. Only that user ever sees it.
+type Bookmark struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BookmarkedChirp struct {
+	Bookmark Bookmark
+	Chirp    Chirp
+}
+
+func (dbStructure *DBStructure) nextBookmarkID() int {
+	id := 0
+	for existing := range dbStructure.Bookmarks {
+		if existing > id {
+			id = existing
+		}
+	}
+	return id + 1
+}
+
+func (dbStructure *DBStructure) bookmark(userID, chirpID int) (Bookmark, bool) {
+	for _, bookmark := range dbStructure.Bookmarks {
+		if bookmark.UserID == userID && bookmark.ChirpID == chirpID {
+			return bookmark, true
+		}
+	}
+	return Bookmark{}, false
+}
+
+// BookmarkChirp is idempotent. It returns ErrNotExist if userID cant see
+// the chirp.
+func (db *DB) BookmarkChirp(userID, chirpID int) (Bookmark, error) {
+	bookmark := Bookmark{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || !chirp.VisibleTo(userID) {
+			return ErrNotExist
+		}
+		if existing, ok := dbStructure.bookmark(userID, chirpID); ok {
+			bookmark = existing
+			return nil
+		}
+		bookmark = Bookmark{
+			ID:        dbStructure.nextBookmarkID(),
+			UserID:    userID,
+			ChirpID:   chirpID,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Bookmarks[bookmark.ID] = bookmark
+		return nil
+	})
+	if err != nil {
+		return Bookmark{}, err
+	}
+	return bookmark, nil
+}
+
+func (db *DB) UnbookmarkChirp(userID, chirpID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if bookmark, ok := dbStructure.bookmark(userID, chirpID); ok {
+			delete(dbStructure.Bookmarks, bookmark.ID)
+		}
+		return nil
+	})
+}
+
+// GetBookmarks returns the users bookmarks older than the bookmark before,
+// newest first. Like feeds it leaves out chirps by authors the user
+// blocked or muted.
+func (db *DB) GetBookmarks(userID, before, limit int) ([]BookmarkedChirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	hidden := dbStructure.hiddenAuthors(userID)
+	bookmarks := []BookmarkedChirp{}
+	for _, bookmark := range dbStructure.Bookmarks {
+		if bookmark.UserID != userID {
+			continue
+		}
+		if before > 0 && bookmark.ID >= before {
+			continue
+		}
+		chirp, ok := dbStructure.Chirps[bookmark.ChirpID]
+		if !ok || !chirp.VisibleTo(userID) {
+			continue
+		}
+		if _, ok := hidden[chirp.AuthorID]; ok {
+			continue
+		}
+		bookmarks = append(bookmarks, BookmarkedChirp{Bookmark: bookmark, Chirp: chirp})
+	}
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return bookmarks[i].Bookmark.ID > bookmarks[j].Bookmark.ID
+	})
+	if limit > 0 && len(bookmarks) > limit {
+		bookmarks = bookmarks[:limit]
+	}
+	return bookmarks, nil
+}
@@ -2,23 +2,50 @@ package database
 
 import (
 	"errors"
+	"time"
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID         int    `json:"id"`
+	Body       string `json:"body"`
+	AuthorID   int    `json:"author_id"`
+	ReplyToID  int    `json:"reply_to_id,omitempty"`
+	MentionIDs []int  `json:"mention_ids,omitempty"`
+	Poll       *Poll  `json:"poll,omitempty"`
+
+	// LinkPreviews are filled in by a background job after publishing.
+	LinkPreviews []LinkPreview `json:"link_previews,omitempty"`
+
+	// Scheduled chirps are only visible to their author until PublishAt.
+	Scheduled bool       `json:"scheduled,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+}
+
+// VisibleTo reports whether viewerID may see the chirp. Zero is anonymous.
+func (chirp Chirp) VisibleTo(viewerID int) bool {
+	return !chirp.Scheduled || chirp.AuthorID == viewerID
 }
 
 var NOT_AUTHORIZED = errors.New("Not authorized")
 
-func (db *DB) GetChirps() ([]Chirp, error) {
+const (
+	TopicChirpCreated = "chirp.created"
+	TopicChirpDeleted = "chirp.deleted"
+)
+
+// GetChirps leaves out the chirps of authors viewerID blocked or muted and
+// scheduled chirps of anyone else.
+func (db *DB) GetChirps(viewerID int) ([]Chirp, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return nil, err
 	}
+	hidden := dbStructure.hiddenAuthors(viewerID)
 	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
 	for _, chirp := range dbStructure.Chirps {
+		if _, ok := hidden[chirp.AuthorID]; ok || !chirp.VisibleTo(viewerID) {
+			continue
+		}
 		chirps = append(chirps, chirp)
 	}
 	return chirps, nil
@@ -36,45 +63,107 @@ func (db *DB) GetChirp(id int) (Chirp, error) {
 	return chirp, nil
 }
 
-func (db *DB) GetAuthorChirps(author_id int) ([]Chirp, error) {
+func (db *DB) GetAuthorChirps(author_id, viewerID int) ([]Chirp, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return nil, err
 	}
 	chirps := make([]Chirp, 0, 20)
+	if _, ok := dbStructure.hiddenAuthors(viewerID)[author_id]; ok {
+		return chirps, nil
+	}
 	for _, chirp := range dbStructure.Chirps {
-		if chirp.AuthorID == author_id {
+		if chirp.AuthorID == author_id && chirp.VisibleTo(viewerID) {
 			chirps = append(chirps, chirp)
 		}
 	}
 	return chirps, nil
 }
 
-func (db *DB) CreateChirp(body string, userID int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+// CreateChirp stores chirp under a new id. It returns ErrNotExist if the
+// chirp it replies to does not exist and ErrBlocked if the author of that
+// chirp, or anyone mentioned, blocked the author.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if err := dbStructure.checkChirp(chirp); err != nil {
+			return err
+		}
+		chirp.ID = dbStructure.nextChirpID()
+		dbStructure.Chirps[chirp.ID] = chirp
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
-	id := len(dbStructure.Chirps) + 1
-	chirp := Chirp{
-		ID:       id,
-		Body:     body,
-		AuthorID: userID,
+	db.publish(TopicChirpCreated, chirp)
+	return chirp, nil
+}
+
+func (dbStructure *DBStructure) checkChirp(chirp Chirp) error {
+	if chirp.ReplyToID != 0 {
+		parent, ok := dbStructure.Chirps[chirp.ReplyToID]
+		if !ok || parent.Scheduled {
+			return ErrNotExist
+		}
+		if dbStructure.blocks(parent.AuthorID, chirp.AuthorID) {
+			return ErrBlocked
+		}
+	}
+	for _, id := range chirp.MentionIDs {
+		if dbStructure.blocks(id, chirp.AuthorID) {
+			return ErrBlocked
+		}
 	}
-	dbStructure.Chirps[id] = chirp
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return Chirp{}, err
+	return nil
+}
+
+// nextChirpID allocates a chirp id. It never hands out the id of a deleted
+// chirp again, since replies and bookmarks may still point at it.
+func (dbStructure *DBStructure) nextChirpID() int {
+	id := max(dbStructure.LastChirpID, len(dbStructure.Chirps))
+	for existing := range dbStructure.Chirps {
+		if existing > id {
+			id = existing
+		}
 	}
-	return chirp, nil
+	dbStructure.LastChirpID = id + 1
+	return dbStructure.LastChirpID
 }
 
 func (db *DB) DeleteChirp(id int) error {
-	dbStructure, err := db.loadDB()
-	delete(dbStructure.Chirps, id)
-	err = db.writeDB(dbStructure)
+	chirp := Chirp{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Chirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		chirp = stored
+		delete(dbStructure.Chirps, id)
+		if stored.Poll != nil {
+			for key, vote := range dbStructure.PollVotes {
+				if vote.ChirpID == id {
+					delete(dbStructure.PollVotes, key)
+				}
+			}
+		}
+		for bookmarkID, bookmark := range dbStructure.Bookmarks {
+			if bookmark.ChirpID == id {
+				delete(dbStructure.Bookmarks, bookmarkID)
+			}
+		}
+		if job, ok := dbStructure.publishJob(id); ok {
+			job.Status = JobCanceled
+			job.FinishedAt = time.Now().UTC()
+			dbStructure.Jobs[job.ID] = job
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
+	// Nobody else has seen a scheduled chirp, so there is nothing to retract.
+	if !chirp.Scheduled {
+		db.publish(TopicChirpDeleted, chirp)
+	}
 	return nil
 }
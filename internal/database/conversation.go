@@ -0,0 +1,269 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	TopicMessageCreated   = "message.created"
+	TopicConversationRead = "conversation.read"
+)
+
+type Conversation struct {
+	ID             int       `json:"id"`
+	ParticipantIDs []int     `json:"participant_ids"`
+	CreatedBy      int       `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastMessageAt  time.Time `json:"last_message_at"`
+	// ReadUpTo holds, per participant, the id of the newest message they
+	// have read.
+	ReadUpTo map[int]int `json:"read_up_to"`
+}
+
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (conversation Conversation) HasParticipant(userID int) bool {
+	for _, id := range conversation.ParticipantIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedSender reports whether another participant blocked senderID.
+func (dbStructure *DBStructure) blockedSender(participantIDs []int, senderID int) bool {
+	for _, id := range participantIDs {
+		if id != senderID && dbStructure.blocks(id, senderID) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameParticipants(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateConversation starts a conversation between creatorID and
+// participantIDs. A 1:1 conversation that already exists is returned
+// instead of starting another. It returns ErrNotExist for unknown users and
+// ErrBlocked if any of them blocked the creator.
+func (db *DB) CreateConversation(creatorID int, participantIDs []int) (Conversation, error) {
+	unique := map[int]struct{}{creatorID: {}}
+	for _, id := range participantIDs {
+		unique[id] = struct{}{}
+	}
+	ids := make([]int, 0, len(unique))
+	for id := range unique {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	conversation := Conversation{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for _, id := range ids {
+			if _, ok := dbStructure.Users[id]; !ok {
+				return ErrNotExist
+			}
+		}
+		if dbStructure.blockedSender(ids, creatorID) {
+			return ErrBlocked
+		}
+		if len(ids) == 2 {
+			for _, existing := range dbStructure.Conversations {
+				if sameParticipants(existing.ParticipantIDs, ids) {
+					conversation = existing
+					return nil
+				}
+			}
+		}
+		now := time.Now().UTC()
+		conversation = Conversation{
+			ID:             len(dbStructure.Conversations) + 1,
+			ParticipantIDs: ids,
+			CreatedBy:      creatorID,
+			CreatedAt:      now,
+			LastMessageAt:  now,
+			ReadUpTo:       map[int]int{},
+		}
+		dbStructure.Conversations[conversation.ID] = conversation
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversation, nil
+}
+
+// GetConversation returns ErrNotExist unless userID takes part in it.
+func (db *DB) GetConversation(conversationID, userID int) (Conversation, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Conversation{}, err
+	}
+	conversation, ok := dbStructure.Conversations[conversationID]
+	if !ok || !conversation.HasParticipant(userID) {
+		return Conversation{}, ErrNotExist
+	}
+	return conversation, nil
+}
+
+// GetConversations returns the users conversations, the most recently
+// active first, with how many messages in each they have not read.
+func (db *DB) GetConversations(userID int) ([]Conversation, map[int]int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	conversations := []Conversation{}
+	for _, conversation := range dbStructure.Conversations {
+		if conversation.HasParticipant(userID) {
+			conversations = append(conversations, conversation)
+		}
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].LastMessageAt.After(conversations[j].LastMessageAt)
+	})
+	unread := map[int]int{}
+	for _, message := range dbStructure.Messages {
+		conversation, ok := dbStructure.Conversations[message.ConversationID]
+		if !ok || !conversation.HasParticipant(userID) || message.SenderID == userID {
+			continue
+		}
+		if message.ID > conversation.ReadUpTo[userID] {
+			unread[conversation.ID]++
+		}
+	}
+	return conversations, unread, nil
+}
+
+// CreateMessage adds a message and counts it as read by its sender. It
+// returns ErrNotExist if the sender is not a participant and ErrBlocked if
+// another participant blocked them.
+func (db *DB) CreateMessage(conversationID, senderID int, body string) (Message, Conversation, error) {
+	message := Message{}
+	conversation := Conversation{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Conversations[conversationID]
+		if !ok || !stored.HasParticipant(senderID) {
+			return ErrNotExist
+		}
+		if dbStructure.blockedSender(stored.ParticipantIDs, senderID) {
+			return ErrBlocked
+		}
+		message = Message{
+			ID:             len(dbStructure.Messages) + 1,
+			ConversationID: conversationID,
+			SenderID:       senderID,
+			Body:           body,
+			CreatedAt:      time.Now().UTC(),
+		}
+		dbStructure.Messages[message.ID] = message
+		if stored.ReadUpTo == nil {
+			stored.ReadUpTo = map[int]int{}
+		}
+		stored.ReadUpTo[senderID] = message.ID
+		stored.LastMessageAt = message.CreatedAt
+		dbStructure.Conversations[conversationID] = stored
+		conversation = stored
+		return nil
+	})
+	if err != nil {
+		return Message{}, Conversation{}, err
+	}
+	for _, id := range conversation.ParticipantIDs {
+		if id != senderID {
+			db.publishTo(id, TopicMessageCreated, message)
+		}
+	}
+	return message, conversation, nil
+}
+
+// GetMessages returns up to limit messages of the conversation, newest
+// first, with ids below before (zero means from the newest).
+func (db *DB) GetMessages(conversationID, userID, before, limit int) ([]Message, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	conversation, ok := dbStructure.Conversations[conversationID]
+	if !ok || !conversation.HasParticipant(userID) {
+		return nil, ErrNotExist
+	}
+	messages := []Message{}
+	for _, message := range dbStructure.Messages {
+		if message.ConversationID != conversationID {
+			continue
+		}
+		if before > 0 && message.ID >= before {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID > messages[j].ID
+	})
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// MarkConversationRead records that userID has read up to messageID, or
+// every message if messageID is zero. Read receipts never move backwards.
+func (db *DB) MarkConversationRead(conversationID, userID, messageID int) (Conversation, error) {
+	conversation := Conversation{}
+	changed := false
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Conversations[conversationID]
+		if !ok || !stored.HasParticipant(userID) {
+			return ErrNotExist
+		}
+		latest := 0
+		for _, message := range dbStructure.Messages {
+			if message.ConversationID == conversationID && message.ID > latest {
+				latest = message.ID
+			}
+		}
+		if messageID == 0 || messageID > latest {
+			messageID = latest
+		}
+		if stored.ReadUpTo == nil {
+			stored.ReadUpTo = map[int]int{}
+		}
+		if messageID > stored.ReadUpTo[userID] {
+			stored.ReadUpTo[userID] = messageID
+			dbStructure.Conversations[conversationID] = stored
+			changed = true
+		}
+		conversation = stored
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	if changed {
+		for _, id := range conversation.ParticipantIDs {
+			if id != userID {
+				db.publishTo(id, TopicConversationRead, conversation)
+			}
+		}
+	}
+	return conversation, nil
+}
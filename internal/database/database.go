@@ -6,6 +6,7 @@ import (
 	"flag"
 	"os"
 	"sync"
+	"time"
 )
 
 var ErrNotExist = errors.New("Resource does not exist")
@@ -13,29 +14,101 @@ var ErrNotExist = errors.New("Resource does not exist")
 type DB struct {
 	path string
 	mux  *sync.RWMutex
+	// txMux serializes read-modify-write cycles made through update.
+	txMux *sync.Mutex
+
+	publisher Publisher
+}
+
+// Publisher receives change events from the database, such as a chirp
+// being created.
+type Publisher interface {
+	Publish(topic string, data interface{})
+	// PublishTo sends an event that only recipient may see.
+	PublishTo(recipient int, topic string, data interface{})
+}
+
+// SetPublisher registers where change events are sent.
+func (db *DB) SetPublisher(publisher Publisher) {
+	db.publisher = publisher
+}
+
+func (db *DB) publish(topic string, data interface{}) {
+	if db.publisher != nil {
+		db.publisher.Publish(topic, data)
+	}
+}
+
+func (db *DB) publishTo(recipient int, topic string, data interface{}) {
+	if db.publisher != nil {
+		db.publisher.PublishTo(recipient, topic, data)
+	}
 }
 
 type DBStructure struct {
 	Chirps      map[int]Chirp         `json:"chirps"`
 	Users       map[int]User          `json:"users"`
-	Revocations map[string]Revocation `json:"revocations`
+	UserEmails  map[string]int        `json:"user_emails"` // by emailKey
+	Revocations map[string]Revocation `json:"revocations"`
+
+	LoginAttempts    map[string]LoginAttempt  `json:"login_attempts"`
+	UsedActionTokens map[string]time.Time     `json:"used_action_tokens"`
+	Identities       map[string]Identity      `json:"identities"`
+	OAuthStates      map[string]OAuthState    `json:"oauth_states"`
+	PersonalTokens   map[string]PersonalToken `json:"personal_tokens"`
+
+	WebhookDeliveries      map[int]WebhookDelivery  `json:"webhook_deliveries"`
+	ProcessedWebhookEvents map[string]time.Time     `json:"processed_webhook_events"`
+	WebhookEndpoints       map[int]WebhookEndpoint  `json:"webhook_endpoints"`
+	OutgoingDeliveries     map[int]OutgoingDelivery `json:"outgoing_deliveries"`
+	Follows                map[string]Follow        `json:"follows"`
+	Notifications          map[int]Notification     `json:"notifications"`
+	Blocks                 map[string]Block         `json:"blocks"`
+	Mutes                  map[string]Mute          `json:"mutes"`
+	Conversations          map[int]Conversation     `json:"conversations"`
+	Messages               map[int]Message          `json:"messages"`
+	Jobs                   map[int]Job              `json:"jobs"`
+	Drafts                 map[int]Draft            `json:"drafts"`
+	PollVotes              map[string]PollVote      `json:"poll_votes"`
+	Bookmarks              map[int]Bookmark         `json:"bookmarks"`
+	Lists                  map[int]List             `json:"lists"`
+	Sessions               map[string]Session       `json:"sessions"`
+	DeletedUsers           map[int]time.Time        `json:"deleted_users"`
+	Exports                map[int]Export           `json:"exports"`
+	AuditLog               map[int]AuditEntry       `json:"audit_log"`
+
+	// LastChirpID is the highest chirp id ever handed out, so deleting the
+	// newest chirps doesnt free their ids.
+	LastChirpID int `json:"last_chirp_id"`
+	// LastJobID is the highest job id ever handed out, so purging finished
+	// jobs doesnt free their ids.
+	LastJobID int `json:"last_job_id"`
+	// LastWebhookDeliveryID is the highest incoming webhook delivery id
+	// ever handed out, so dropping old deliveries doesnt free their ids.
+	LastWebhookDeliveryID int `json:"last_webhook_delivery_id"`
+	// LastWebhookEndpointID and LastOutgoingDeliveryID are the highest
+	// outgoing webhook ids ever handed out, so a new endpoint never takes
+	// over the deliveries of a deleted one.
+	LastWebhookEndpointID  int `json:"last_webhook_endpoint_id"`
+	LastOutgoingDeliveryID int `json:"last_outgoing_delivery_id"`
+	// LastNotificationID is the highest notification id ever handed out,
+	// so deleting a users notifications doesnt free their ids.
+	LastNotificationID int `json:"last_notification_id"`
 }
 
 func NewDB(path string) (*DB, error) {
 	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:  path,
+		mux:   &sync.RWMutex{},
+		txMux: &sync.Mutex{},
 	}
 
 	err := db.ensureDB()
 	dbg := flag.Bool("debug", false, "Enable debug mode")
 	flag.Parse()
 	if *dbg {
-		emptyStructure := DBStructure{
-			Chirps:      map[int]Chirp{},
-			Users:       map[int]User{},
-			Revocations: map[string]Revocation{},
-		}
+		emptyStructure := DBStructure{}
+		emptyStructure.ensureMaps()
 		db.writeDB(emptyStructure)
 	}
 	return db, err
@@ -50,13 +123,99 @@ func (db *DB) ensureDB() error {
 }
 
 func (db *DB) createDB() error {
-	dbStructure := DBStructure{
-		Chirps: map[int]Chirp{},
-		Users:  map[int]User{},
-	}
+	dbStructure := DBStructure{}
+	dbStructure.ensureMaps()
 	return db.writeDB(dbStructure)
 }
 
+// ensureMaps initializes collections missing from older database files.
+func (dbStructure *DBStructure) ensureMaps() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = map[int]Chirp{}
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = map[int]User{}
+	}
+	if dbStructure.UserEmails == nil {
+		dbStructure.buildEmailIndex()
+	}
+	if dbStructure.Revocations == nil {
+		dbStructure.Revocations = map[string]Revocation{}
+	}
+	if dbStructure.LoginAttempts == nil {
+		dbStructure.LoginAttempts = map[string]LoginAttempt{}
+	}
+	if dbStructure.UsedActionTokens == nil {
+		dbStructure.UsedActionTokens = map[string]time.Time{}
+	}
+	if dbStructure.Identities == nil {
+		dbStructure.Identities = map[string]Identity{}
+	}
+	if dbStructure.OAuthStates == nil {
+		dbStructure.OAuthStates = map[string]OAuthState{}
+	}
+	if dbStructure.PersonalTokens == nil {
+		dbStructure.PersonalTokens = map[string]PersonalToken{}
+	}
+	if dbStructure.WebhookDeliveries == nil {
+		dbStructure.WebhookDeliveries = map[int]WebhookDelivery{}
+	}
+	if dbStructure.ProcessedWebhookEvents == nil {
+		dbStructure.ProcessedWebhookEvents = map[string]time.Time{}
+	}
+	if dbStructure.WebhookEndpoints == nil {
+		dbStructure.WebhookEndpoints = map[int]WebhookEndpoint{}
+	}
+	if dbStructure.OutgoingDeliveries == nil {
+		dbStructure.OutgoingDeliveries = map[int]OutgoingDelivery{}
+	}
+	if dbStructure.Follows == nil {
+		dbStructure.Follows = map[string]Follow{}
+	}
+	if dbStructure.Notifications == nil {
+		dbStructure.Notifications = map[int]Notification{}
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = map[string]Block{}
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = map[string]Mute{}
+	}
+	if dbStructure.Conversations == nil {
+		dbStructure.Conversations = map[int]Conversation{}
+	}
+	if dbStructure.Messages == nil {
+		dbStructure.Messages = map[int]Message{}
+	}
+	if dbStructure.Jobs == nil {
+		dbStructure.Jobs = map[int]Job{}
+	}
+	if dbStructure.Drafts == nil {
+		dbStructure.Drafts = map[int]Draft{}
+	}
+	if dbStructure.PollVotes == nil {
+		dbStructure.PollVotes = map[string]PollVote{}
+	}
+	if dbStructure.Bookmarks == nil {
+		dbStructure.Bookmarks = map[int]Bookmark{}
+	}
+	if dbStructure.Lists == nil {
+		dbStructure.Lists = map[int]List{}
+	}
+	if dbStructure.Sessions == nil {
+		dbStructure.Sessions = map[string]Session{}
+	}
+	if dbStructure.DeletedUsers == nil {
+		dbStructure.DeletedUsers = map[int]time.Time{}
+	}
+	if dbStructure.Exports == nil {
+		dbStructure.Exports = map[int]Export{}
+	}
+	if dbStructure.AuditLog == nil {
+		dbStructure.AuditLog = map[int]AuditEntry{}
+	}
+}
+
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
@@ -67,12 +226,30 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return dbStructure, err
 	}
 	err = json.Unmarshal(data, &dbStructure)
+	dbStructure.ensureMaps()
 	if err != nil {
 		return dbStructure, nil
 	}
 	return dbStructure, nil
 }
 
+// update loads the database, applies fn and writes the result back without
+// letting another update run in between. Nothing is written if fn fails.
+func (db *DB) update(fn func(dbStructure *DBStructure) error) error {
+	db.txMux.Lock()
+	defer db.txMux.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	err = fn(&dbStructure)
+	if err != nil {
+		return err
+	}
+	return db.writeDB(dbStructure)
+}
+
 func (db *DB) writeDB(dbStructure DBStructure) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrDraftLimit   = errors.New("Draft limit reached")
+	ErrDraftChanged = errors.New("Draft was changed")
+	// ErrDraftNotExist is the ErrNotExist PublishDraft returns for the draft
+	// itself, as opposed to the chirp it replies to.
+	ErrDraftNotExist = fmt.Errorf("%w: draft", ErrNotExist)
+)
+
+// Draft is an unpublished chirp. Its body is only validated when it is
+// published.
+type Draft struct {
+	ID        int       `json:"id"`
+	AuthorID  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	ReplyToID int       `json:"reply_to_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateDraft returns ErrDraftLimit if the author already has maxDrafts.
+func (db *DB) CreateDraft(draft Draft, maxDrafts int) (Draft, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		count := 0
+		id := len(dbStructure.Drafts)
+		for existingID, existing := range dbStructure.Drafts {
+			if existing.AuthorID == draft.AuthorID {
+				count++
+			}
+			if existingID > id {
+				id = existingID
+			}
+		}
+		if count >= maxDrafts {
+			return ErrDraftLimit
+		}
+		now := time.Now().UTC()
+		draft.ID = id + 1
+		draft.CreatedAt = now
+		draft.UpdatedAt = now
+		dbStructure.Drafts[draft.ID] = draft
+		return nil
+	})
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDrafts returns the authors drafts, the most recently edited first.
+func (db *DB) GetDrafts(authorID int) ([]Draft, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	drafts := []Draft{}
+	for _, draft := range dbStructure.Drafts {
+		if draft.AuthorID == authorID {
+			drafts = append(drafts, draft)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].UpdatedAt.After(drafts[j].UpdatedAt)
+	})
+	return drafts, nil
+}
+
+// GetDraft returns ErrNotExist unless the draft belongs to authorID.
+func (db *DB) GetDraft(authorID, draftID int) (Draft, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+	draft, ok := dbStructure.Drafts[draftID]
+	if !ok || draft.AuthorID != authorID {
+		return Draft{}, ErrNotExist
+	}
+	return draft, nil
+}
+
+func (db *DB) UpdateDraft(authorID, draftID int, body string, replyToID int) (Draft, error) {
+	draft := Draft{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Drafts[draftID]
+		if !ok || stored.AuthorID != authorID {
+			return ErrNotExist
+		}
+		stored.Body = body
+		stored.ReplyToID = replyToID
+		stored.UpdatedAt = time.Now().UTC()
+		dbStructure.Drafts[draftID] = stored
+		draft = stored
+		return nil
+	})
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+func (db *DB) DeleteDraft(authorID, draftID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Drafts[draftID]
+		if !ok || stored.AuthorID != authorID {
+			return ErrNotExist
+		}
+		delete(dbStructure.Drafts, draftID)
+		return nil
+	})
+}
+
+// PublishDraft turns the draft into chirp in one update: either the chirp
+// exists and the draft is gone, or nothing changed. chirp is built from the
+// draft as it was at version; ErrDraftChanged is returned if it was edited
+// since, and ErrDraftNotExist if it was deleted.
+func (db *DB) PublishDraft(authorID, draftID int, version time.Time, chirp Chirp) (Chirp, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		draft, ok := dbStructure.Drafts[draftID]
+		if !ok || draft.AuthorID != authorID {
+			return ErrDraftNotExist
+		}
+		if !draft.UpdatedAt.Equal(version) {
+			return ErrDraftChanged
+		}
+		if err := dbStructure.checkChirp(chirp); err != nil {
+			return err
+		}
+		chirp.ID = dbStructure.nextChirpID()
+		dbStructure.Chirps[chirp.ID] = chirp
+		delete(dbStructure.Drafts, draftID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	db.publish(TopicChirpCreated, chirp)
+	return chirp, nil
+}
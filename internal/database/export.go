@@ -0,0 +1,242 @@
+package database
+
+import (
+	"time"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportReady   ExportStatus = "ready"
+)
+
+// JobExportUserData writes the data export SubjectID.
+const JobExportUserData = "export-user-data"
+
+// Export is a zip of everything stored about a user. It can be downloaded
+// once, after which it is gone.
+type Export struct {
+	ID        int          `json:"id"`
+	UserID    int          `json:"user_id"`
+	Status    ExportStatus `json:"status"`
+	File      string       `json:"file,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	ReadyAt   time.Time    `json:"ready_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// UserData is everything stored about a user, for their export.
+type UserData struct {
+	User           User
+	Chirps         []Chirp
+	Drafts         []Draft
+	Bookmarks      []Bookmark
+	PollVotes      []PollVote
+	Lists          []List
+	Follows        []Follow
+	Blocks         []Block
+	Mutes          []Mute
+	Messages       []Message
+	Sessions       []Session
+	PersonalTokens []PersonalToken
+	Identities     []Identity
+}
+
+func (dbStructure *DBStructure) nextExportID() int {
+	id := 0
+	for existing := range dbStructure.Exports {
+		if existing > id {
+			id = existing
+		}
+	}
+	return id + 1
+}
+
+// RequestExport returns the users export that is being prepared, or
+// queues a new one together with the job that writes it.
+func (db *DB) RequestExport(userID int) (Export, error) {
+	export := Export{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for _, existing := range dbStructure.Exports {
+			if existing.UserID == userID && existing.Status == ExportPending {
+				export = existing
+				return nil
+			}
+		}
+		export = Export{
+			ID:        dbStructure.nextExportID(),
+			UserID:    userID,
+			Status:    ExportPending,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Exports[export.ID] = export
+		dbStructure.addJob(Job{
+			Kind:      JobExportUserData,
+			SubjectID: export.ID,
+			OwnerID:   userID,
+			RunAt:     export.CreatedAt,
+		})
+		return nil
+	})
+	if err != nil {
+		return Export{}, err
+	}
+	return export, nil
+}
+
+func (db *DB) GetExport(id int) (Export, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Export{}, err
+	}
+	export, ok := dbStructure.Exports[id]
+	if !ok {
+		return Export{}, ErrNotExist
+	}
+	return export, nil
+}
+
+// CompleteExport marks the export as ready to download from file until
+// expiresAt. It returns ErrNotExist if the export is gone, because its
+// user was deleted while it was written.
+func (db *DB) CompleteExport(id int, file string, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		export, ok := dbStructure.Exports[id]
+		if !ok {
+			return ErrNotExist
+		}
+		export.Status = ExportReady
+		export.File = file
+		export.ReadyAt = time.Now().UTC()
+		export.ExpiresAt = expiresAt
+		dbStructure.Exports[id] = export
+		return nil
+	})
+}
+
+// TakeExport removes the users ready export and returns it, so it can be
+// downloaded only once. It returns ErrNotExist if there is none.
+func (db *DB) TakeExport(userID int, now time.Time) (Export, error) {
+	export := Export{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, existing := range dbStructure.Exports {
+			if existing.UserID == userID && existing.Status == ExportReady && existing.ExpiresAt.After(now) {
+				export = existing
+				delete(dbStructure.Exports, id)
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
+	if err != nil {
+		return Export{}, err
+	}
+	return export, nil
+}
+
+// PurgeExpiredExports deletes exports that were not downloaded in time and
+// returns their files.
+func (db *DB) PurgeExpiredExports(now time.Time) ([]string, error) {
+	files := []string{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, export := range dbStructure.Exports {
+			if export.Status == ExportReady && !export.ExpiresAt.After(now) {
+				files = append(files, export.File)
+				delete(dbStructure.Exports, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (db *DB) GetUserData(userID int) (UserData, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return UserData{}, err
+	}
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return UserData{}, ErrNotExist
+	}
+	data := UserData{
+		User:           user,
+		Chirps:         []Chirp{},
+		Drafts:         []Draft{},
+		Bookmarks:      []Bookmark{},
+		PollVotes:      []PollVote{},
+		Lists:          []List{},
+		Follows:        []Follow{},
+		Blocks:         []Block{},
+		Mutes:          []Mute{},
+		Messages:       []Message{},
+		Sessions:       []Session{},
+		PersonalTokens: []PersonalToken{},
+		Identities:     []Identity{},
+	}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == userID {
+			data.Chirps = append(data.Chirps, chirp)
+		}
+	}
+	for _, draft := range dbStructure.Drafts {
+		if draft.AuthorID == userID {
+			data.Drafts = append(data.Drafts, draft)
+		}
+	}
+	for _, bookmark := range dbStructure.Bookmarks {
+		if bookmark.UserID == userID {
+			data.Bookmarks = append(data.Bookmarks, bookmark)
+		}
+	}
+	for _, vote := range dbStructure.PollVotes {
+		if vote.UserID == userID {
+			data.PollVotes = append(data.PollVotes, vote)
+		}
+	}
+	for _, list := range dbStructure.Lists {
+		if list.OwnerID == userID {
+			data.Lists = append(data.Lists, list)
+		}
+	}
+	for _, follow := range dbStructure.Follows {
+		if follow.FollowerID == userID {
+			data.Follows = append(data.Follows, follow)
+		}
+	}
+	for _, block := range dbStructure.Blocks {
+		if block.BlockerID == userID {
+			data.Blocks = append(data.Blocks, block)
+		}
+	}
+	for _, mute := range dbStructure.Mutes {
+		if mute.MuterID == userID {
+			data.Mutes = append(data.Mutes, mute)
+		}
+	}
+	for _, message := range dbStructure.Messages {
+		if message.SenderID == userID {
+			data.Messages = append(data.Messages, message)
+		}
+	}
+	for _, session := range dbStructure.Sessions {
+		if session.UserID == userID {
+			data.Sessions = append(data.Sessions, session)
+		}
+	}
+	for _, token := range dbStructure.PersonalTokens {
+		if token.UserID == userID {
+			data.PersonalTokens = append(data.PersonalTokens, token)
+		}
+	}
+	for _, identity := range dbStructure.Identities {
+		if identity.UserID == userID {
+			data.Identities = append(data.Identities, identity)
+		}
+	}
+	return data, nil
+}
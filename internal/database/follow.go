@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func followKey(followerID, followeeID int) string {
+	return fmt.Sprintf("%d:%d", followerID, followeeID)
+}
+
+// FollowUser is idempotent; created reports whether the follow is new. It
+// returns ErrBlocked if the followee blocked the follower.
+func (db *DB) FollowUser(followerID, followeeID int) (follow Follow, created bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[followeeID]; !ok {
+			return ErrNotExist
+		}
+		if dbStructure.blocks(followeeID, followerID) {
+			return ErrBlocked
+		}
+		key := followKey(followerID, followeeID)
+		if existing, ok := dbStructure.Follows[key]; ok {
+			follow = existing
+			return nil
+		}
+		follow = Follow{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		}
+		dbStructure.Follows[key] = follow
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Follow{}, false, err
+	}
+	return follow, created, nil
+}
+
+func (db *DB) UnfollowUser(followerID, followeeID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Follows, followKey(followerID, followeeID))
+		return nil
+	})
+}
+
+// GetFollowees returns the ids of the users followerID follows.
+func (db *DB) GetFollowees(followerID int) ([]int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for _, follow := range dbStructure.Follows {
+		if follow.FollowerID == followerID {
+			ids = append(ids, follow.FolloweeID)
+		}
+	}
+	return ids, nil
+}
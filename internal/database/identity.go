@@ -0,0 +1,95 @@
+package database
+
+import "time"
+
+// Identity links an account at an external OpenID Connect provider to a
+// Chirpy user.
+type Identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	UserID   int       `json:"user_id"`
+	Email    string    `json:"email"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// OAuthState holds what the callback of a login flow needs to finish it.
+type OAuthState struct {
+	State        string    `json:"state"`
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func identityKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+func (db *DB) GetIdentity(provider, subject string) (Identity, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Identity{}, err
+	}
+	identity, ok := dbStructure.Identities[identityKey(provider, subject)]
+	if !ok {
+		return Identity{}, ErrNotExist
+	}
+	return identity, nil
+}
+
+func (db *DB) CreateIdentity(provider, subject string, userID int, email string) (Identity, error) {
+	identity := Identity{
+		Provider: provider,
+		Subject:  subject,
+		UserID:   userID,
+		Email:    email,
+		LinkedAt: time.Now().UTC(),
+	}
+	err := db.update(func(dbStructure *DBStructure) error {
+		key := identityKey(provider, subject)
+		if _, ok := dbStructure.Identities[key]; ok {
+			return ErrAlreadyExists
+		}
+		dbStructure.Identities[key] = identity
+		return nil
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+	return identity, nil
+}
+
+func (db *DB) SaveOAuthState(state OAuthState) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for key, stored := range dbStructure.OAuthStates {
+			if stored.ExpiresAt.Before(now) {
+				delete(dbStructure.OAuthStates, key)
+			}
+		}
+		dbStructure.OAuthStates[state.State] = state
+		return nil
+	})
+}
+
+// TakeOAuthState returns the state and deletes it so it can only be used
+// once. Expired states are reported as ErrNotExist.
+func (db *DB) TakeOAuthState(state string) (OAuthState, error) {
+	stored := OAuthState{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		found, ok := dbStructure.OAuthStates[state]
+		if !ok {
+			return ErrNotExist
+		}
+		delete(dbStructure.OAuthStates, state)
+		stored = found
+		return nil
+	})
+	if err != nil {
+		return OAuthState{}, err
+	}
+	if stored.ExpiresAt.Before(time.Now().UTC()) {
+		return OAuthState{}, ErrNotExist
+	}
+	return stored, nil
+}
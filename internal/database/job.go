@@ -0,0 +1,214 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobPending  JobStatus = "pending"
+	JobRunning  JobStatus = "running"
+	JobDone     JobStatus = "done"
+	JobFailed   JobStatus = "failed"
+	JobCanceled JobStatus = "canceled"
+)
+
+// JobPublishChirp publishes the scheduled chirp SubjectID.
+const JobPublishChirp = "publish-chirp"
+
+var ErrJobRunning = errors.New("Job is already running")
+
+// Job is a unit of background work persisted so it survives restarts.
+// Periodic jobs have a Name and an Interval and are never done; after each
+// run they are pending again for the next one.
+type Job struct {
+	ID          int       `json:"id"`
+	Kind        string    `json:"kind"`
+	Name        string    `json:"name,omitempty"`
+	SubjectID   int       `json:"subject_id,omitempty"`
+	OwnerID     int       `json:"owner_id,omitempty"`
+	Interval    int64     `json:"interval_seconds,omitempty"`
+	RunAt       time.Time `json:"run_at"`
+	Status      JobStatus `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+func (job Job) finished() bool {
+	return job.Status == JobDone || job.Status == JobFailed || job.Status == JobCanceled
+}
+
+func (dbStructure *DBStructure) addJob(job Job) Job {
+	id := max(dbStructure.LastJobID, len(dbStructure.Jobs))
+	for existing := range dbStructure.Jobs {
+		if existing > id {
+			id = existing
+		}
+	}
+	dbStructure.LastJobID = id + 1
+	job.ID = dbStructure.LastJobID
+	job.Status = JobPending
+	job.CreatedAt = time.Now().UTC()
+	dbStructure.Jobs[job.ID] = job
+	return job
+}
+
+func (db *DB) CreateJob(job Job) (Job, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		job = dbStructure.addJob(job)
+		return nil
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// EnsurePeriodicJob creates the periodic job name on first start and
+// updates its interval afterwards, keeping its next run time.
+func (db *DB) EnsurePeriodicJob(name string, interval time.Duration) (Job, error) {
+	job := Job{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, stored := range dbStructure.Jobs {
+			if stored.Name != name {
+				continue
+			}
+			stored.Interval = int64(interval / time.Second)
+			dbStructure.Jobs[id] = stored
+			job = stored
+			return nil
+		}
+		job = dbStructure.addJob(Job{
+			Kind:     name,
+			Name:     name,
+			Interval: int64(interval / time.Second),
+			RunAt:    time.Now().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimDueJobs marks up to limit due jobs as running until now plus lease
+// and returns them. Claiming happens in one update, so a job is only handed
+// out once. Running jobs whose lease ran out, because the server stopped
+// while running them, are due again.
+func (db *DB) ClaimDueJobs(now time.Time, lease time.Duration, limit int) ([]Job, error) {
+	claimed := []Job{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		due := []Job{}
+		for _, job := range dbStructure.Jobs {
+			switch job.Status {
+			case JobPending:
+				if job.RunAt.After(now) {
+					continue
+				}
+			case JobRunning:
+				if job.LockedUntil.After(now) {
+					continue
+				}
+			default:
+				continue
+			}
+			due = append(due, job)
+		}
+		sort.Slice(due, func(i, j int) bool {
+			return due[i].RunAt.Before(due[j].RunAt)
+		})
+		if limit > 0 && len(due) > limit {
+			due = due[:limit]
+		}
+		for _, job := range due {
+			job.Status = JobRunning
+			job.Attempts++
+			job.LockedUntil = now.Add(lease)
+			dbStructure.Jobs[job.ID] = job
+			claimed = append(claimed, job)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// CompleteJob finishes a run. Periodic jobs are scheduled again for
+// nextRunAt.
+func (db *DB) CompleteJob(id int, nextRunAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		job, ok := dbStructure.Jobs[id]
+		if !ok {
+			return ErrNotExist
+		}
+		job.LockedUntil = time.Time{}
+		job.LastError = ""
+		if job.Interval > 0 {
+			job.Status = JobPending
+			job.Attempts = 0
+			job.RunAt = nextRunAt
+		} else {
+			job.Status = JobDone
+			job.FinishedAt = time.Now().UTC()
+		}
+		dbStructure.Jobs[id] = job
+		return nil
+	})
+}
+
+// FailJob records a failed run. The job is retried at retryAt unless dead,
+// in which case it is given up on.
+func (db *DB) FailJob(id int, message string, retryAt time.Time, dead bool) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		job, ok := dbStructure.Jobs[id]
+		if !ok {
+			return ErrNotExist
+		}
+		job.LockedUntil = time.Time{}
+		job.LastError = message
+		if dead {
+			job.Status = JobFailed
+			job.FinishedAt = time.Now().UTC()
+		} else {
+			job.Status = JobPending
+			job.RunAt = retryAt
+		}
+		dbStructure.Jobs[id] = job
+		return nil
+	})
+}
+
+// PurgeTombstones deletes records that only exist so something is not done
+// twice, once they are older than before: finished jobs and the ids of
+// processed incoming webhook events. It returns how many were deleted.
+func (db *DB) PurgeTombstones(before time.Time) (int, error) {
+	purged := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, job := range dbStructure.Jobs {
+			if job.finished() && job.FinishedAt.Before(before) {
+				delete(dbStructure.Jobs, id)
+				purged++
+			}
+		}
+		for key, processedAt := range dbStructure.ProcessedWebhookEvents {
+			if processedAt.Before(before) {
+				delete(dbStructure.ProcessedWebhookEvents, key)
+				purged++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
@@ -0,0 +1,28 @@
+package database
+
+// JobFetchLinkPreviews fetches the previews of the links in chirp
+// SubjectID.
+const JobFetchLinkPreviews = "fetch-link-previews"
+
+// LinkPreview is the card shown for a link in a chirp.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// SetLinkPreviews stores the previews of a chirp. A chirp deleted in the
+// meantime is left deleted.
+func (db *DB) SetLinkPreviews(chirpID int, previews []LinkPreview) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok {
+			return nil
+		}
+		chirp.LinkPreviews = previews
+		dbStructure.Chirps[chirpID] = chirp
+		return nil
+	})
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrListLimit = errors.New("List limit reached")
+	ErrListFull  = errors.New("List is full")
+)
+
+// List is a private, named set of authors whose chirps can be read as one
+// feed.
+type List struct {
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	Name      string    `json:"name"`
+	MemberIDs []int     `json:"member_ids"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (list List) hasMember(userID int) bool {
+	for _, id := range list.MemberIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (dbStructure *DBStructure) nextListID() int {
+	id := 0
+	for existing := range dbStructure.Lists {
+		if existing > id {
+			id = existing
+		}
+	}
+	return id + 1
+}
+
+// ownedList returns ErrNotExist unless listID belongs to ownerID, so
+// nobody learns which lists other users have.
+func (dbStructure *DBStructure) ownedList(ownerID, listID int) (List, error) {
+	list, ok := dbStructure.Lists[listID]
+	if !ok || list.OwnerID != ownerID {
+		return List{}, ErrNotExist
+	}
+	return list, nil
+}
+
+// CreateList returns ErrListLimit if the owner already has maxLists.
+func (db *DB) CreateList(ownerID int, name string, maxLists int) (List, error) {
+	list := List{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		count := 0
+		for _, existing := range dbStructure.Lists {
+			if existing.OwnerID == ownerID {
+				count++
+			}
+		}
+		if count >= maxLists {
+			return ErrListLimit
+		}
+		now := time.Now().UTC()
+		list = List{
+			ID:        dbStructure.nextListID(),
+			OwnerID:   ownerID,
+			Name:      name,
+			MemberIDs: []int{},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		dbStructure.Lists[list.ID] = list
+		return nil
+	})
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+func (db *DB) GetLists(ownerID int) ([]List, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	lists := []List{}
+	for _, list := range dbStructure.Lists {
+		if list.OwnerID == ownerID {
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].ID < lists[j].ID
+	})
+	return lists, nil
+}
+
+func (db *DB) GetList(ownerID, listID int) (List, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return List{}, err
+	}
+	return dbStructure.ownedList(ownerID, listID)
+}
+
+// updateList applies fn to the owners list and stores the result.
+func (db *DB) updateList(ownerID, listID int, fn func(dbStructure *DBStructure, list *List) error) (List, error) {
+	list := List{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, err := dbStructure.ownedList(ownerID, listID)
+		if err != nil {
+			return err
+		}
+		if err := fn(dbStructure, &stored); err != nil {
+			return err
+		}
+		stored.UpdatedAt = time.Now().UTC()
+		dbStructure.Lists[listID] = stored
+		list = stored
+		return nil
+	})
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+func (db *DB) RenameList(ownerID, listID int, name string) (List, error) {
+	return db.updateList(ownerID, listID, func(dbStructure *DBStructure, list *List) error {
+		list.Name = name
+		return nil
+	})
+}
+
+func (db *DB) DeleteList(ownerID, listID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, err := dbStructure.ownedList(ownerID, listID); err != nil {
+			return err
+		}
+		delete(dbStructure.Lists, listID)
+		return nil
+	})
+}
+
+// AddListMember is idempotent. It returns ErrNotExist if the list or the
+// user does not exist and ErrListFull if the list has maxMembers.
+func (db *DB) AddListMember(ownerID, listID, memberID, maxMembers int) (List, error) {
+	return db.updateList(ownerID, listID, func(dbStructure *DBStructure, list *List) error {
+		if _, ok := dbStructure.Users[memberID]; !ok {
+			return ErrNotExist
+		}
+		if list.hasMember(memberID) {
+			return nil
+		}
+		if len(list.MemberIDs) >= maxMembers {
+			return ErrListFull
+		}
+		list.MemberIDs = append(list.MemberIDs, memberID)
+		return nil
+	})
+}
+
+func (db *DB) RemoveListMember(ownerID, listID, memberID int) (List, error) {
+	return db.updateList(ownerID, listID, func(dbStructure *DBStructure, list *List) error {
+		members := make([]int, 0, len(list.MemberIDs))
+		for _, id := range list.MemberIDs {
+			if id != memberID {
+				members = append(members, id)
+			}
+		}
+		list.MemberIDs = members
+		return nil
+	})
+}
+
+// GetListChirps merges the chirps of the lists members into one feed,
+// newest first, starting below the chirp id before. Members the owner has
+// since blocked or muted are left out.
+func (db *DB) GetListChirps(ownerID, listID, before, limit int) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	list, err := dbStructure.ownedList(ownerID, listID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := dbStructure.hiddenAuthors(ownerID)
+	members := map[int]struct{}{}
+	for _, id := range list.MemberIDs {
+		if _, ok := hidden[id]; !ok {
+			members[id] = struct{}{}
+		}
+	}
+	chirps := []Chirp{}
+	for _, chirp := range dbStructure.Chirps {
+		if _, ok := members[chirp.AuthorID]; !ok || !chirp.VisibleTo(ownerID) {
+			continue
+		}
+		if before > 0 && chirp.ID >= before {
+			continue
+		}
+		chirps = append(chirps, chirp)
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].ID > chirps[j].ID
+	})
+	if limit > 0 && len(chirps) > limit {
+		chirps = chirps[:limit]
+	}
+	return chirps, nil
+}
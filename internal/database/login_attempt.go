@@ -0,0 +1,45 @@
+package database
+
+import "time"
+
+type LoginAttempt struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// UpdateLoginAttempts applies fn to the attempts of keys, in that order, in
+// one update, so checking a lockout and counting an attempt cant interleave
+// with another request. Attempts left without failures or a lockout are
+// deleted.
+func (db *DB) UpdateLoginAttempts(keys []string, fn func(attempts []*LoginAttempt) error) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		attempts := make([]*LoginAttempt, 0, len(keys))
+		for _, key := range keys {
+			attempt, ok := dbStructure.LoginAttempts[key]
+			if !ok {
+				attempt = LoginAttempt{Key: key}
+			}
+			attempts = append(attempts, &attempt)
+		}
+		if err := fn(attempts); err != nil {
+			return err
+		}
+		for _, attempt := range attempts {
+			if attempt.Failures == 0 && attempt.LockedUntil.IsZero() {
+				delete(dbStructure.LoginAttempts, attempt.Key)
+				continue
+			}
+			dbStructure.LoginAttempts[attempt.Key] = *attempt
+		}
+		return nil
+	})
+}
+
+func (db *DB) DeleteLoginAttempt(key string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.LoginAttempts, key)
+		return nil
+	})
+}
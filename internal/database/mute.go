@@ -0,0 +1,64 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Mute hides a user's content from the muter without them knowing. Unlike
+// a block it does not stop them from interacting.
+type Mute struct {
+	MuterID   int       `json:"muter_id"`
+	MutedID   int       `json:"muted_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) MuteUser(muterID, mutedID int) (Mute, error) {
+	mute := Mute{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[mutedID]; !ok {
+			return ErrNotExist
+		}
+		key := followKey(muterID, mutedID)
+		if existing, ok := dbStructure.Mutes[key]; ok {
+			mute = existing
+			return nil
+		}
+		mute = Mute{
+			MuterID:   muterID,
+			MutedID:   mutedID,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Mutes[key] = mute
+		return nil
+	})
+	if err != nil {
+		return Mute{}, err
+	}
+	return mute, nil
+}
+
+func (db *DB) UnmuteUser(muterID, mutedID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Mutes, followKey(muterID, mutedID))
+		return nil
+	})
+}
+
+// GetMutes returns the mutes muterID has made.
+func (db *DB) GetMutes(muterID int) ([]Mute, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	mutes := []Mute{}
+	for _, mute := range dbStructure.Mutes {
+		if mute.MuterID == muterID {
+			mutes = append(mutes, mute)
+		}
+	}
+	sort.Slice(mutes, func(i, j int) bool {
+		return mutes[i].CreatedAt.Before(mutes[j].CreatedAt)
+	})
+	return mutes, nil
+}
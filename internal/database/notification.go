@@ -0,0 +1,190 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+type NotificationType string
+
+const (
+	NotificationReply        NotificationType = "reply"
+	NotificationMention      NotificationType = "mention"
+	NotificationFollow       NotificationType = "follow"
+	NotificationSubscription NotificationType = "subscription"
+)
+
+var NotificationTypes = []NotificationType{
+	NotificationReply,
+	NotificationMention,
+	NotificationFollow,
+	NotificationSubscription,
+}
+
+func ParseNotificationType(value string) (NotificationType, bool) {
+	for _, notificationType := range NotificationTypes {
+		if string(notificationType) == value {
+			return notificationType, true
+		}
+	}
+	return "", false
+}
+
+const TopicNotificationCreated = "notification.created"
+
+type Notification struct {
+	ID        int              `json:"id"`
+	UserID    int              `json:"user_id"`
+	Type      NotificationType `json:"type"`
+	ActorID   int              `json:"actor_id,omitempty"`
+	ChirpID   int              `json:"chirp_id,omitempty"`
+	Detail    string           `json:"detail,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	ReadAt    *time.Time       `json:"read_at"`
+}
+
+// WantsNotification reports whether the user has notificationType turned
+// on.
+func (user User) WantsNotification(notificationType NotificationType) bool {
+	enabled, ok := user.NotificationPreferences[notificationType]
+	return !ok || enabled
+}
+
+// CreateNotification stores notification for its user unless they turned
+// its type off, caused it themselves or blocked or muted its actor. created
+// is false if it was skipped.
+func (db *DB) CreateNotification(notification Notification) (Notification, bool, error) {
+	created := false
+	err := db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[notification.UserID]
+		if !ok {
+			return ErrNotExist
+		}
+		if notification.ActorID == notification.UserID || !user.WantsNotification(notification.Type) {
+			return nil
+		}
+		if _, hidden := dbStructure.hiddenAuthors(notification.UserID)[notification.ActorID]; hidden {
+			return nil
+		}
+		notification.ID = dbStructure.nextNotificationID()
+		notification.CreatedAt = time.Now().UTC()
+		notification.ReadAt = nil
+		dbStructure.Notifications[notification.ID] = notification
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Notification{}, false, err
+	}
+	if created {
+		db.publishTo(notification.UserID, TopicNotificationCreated, notification)
+	}
+	return notification, created, nil
+}
+
+// GetNotifications returns up to limit of the user's notifications, newest
+// first, with ids below before (zero means from the newest). unread is the
+// user's total number of unread notifications. Notifications caused by users
+// they blocked or muted are left out.
+func (db *DB) GetNotifications(userID, before, limit int, unreadOnly bool) (notifications []Notification, unread int, err error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, 0, err
+	}
+	hidden := dbStructure.hiddenAuthors(userID)
+	notifications = []Notification{}
+	for _, notification := range dbStructure.Notifications {
+		if notification.UserID != userID {
+			continue
+		}
+		if _, ok := hidden[notification.ActorID]; ok {
+			continue
+		}
+		if notification.ReadAt == nil {
+			unread++
+		} else if unreadOnly {
+			continue
+		}
+		if before > 0 && notification.ID >= before {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ID > notifications[j].ID
+	})
+	if limit > 0 && len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, unread, nil
+}
+
+// MarkNotificationRead returns ErrNotExist if the notification does not
+// belong to userID.
+func (db *DB) MarkNotificationRead(userID, notificationID int) (Notification, error) {
+	notification := Notification{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Notifications[notificationID]
+		if !ok || stored.UserID != userID {
+			return ErrNotExist
+		}
+		if stored.ReadAt == nil {
+			now := time.Now().UTC()
+			stored.ReadAt = &now
+			dbStructure.Notifications[notificationID] = stored
+		}
+		notification = stored
+		return nil
+	})
+	if err != nil {
+		return Notification{}, err
+	}
+	return notification, nil
+}
+
+// MarkAllNotificationsRead returns how many notifications were unread.
+func (db *DB) MarkAllNotificationsRead(userID int) (int, error) {
+	marked := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for id, notification := range dbStructure.Notifications {
+			if notification.UserID != userID || notification.ReadAt != nil {
+				continue
+			}
+			notification.ReadAt = &now
+			dbStructure.Notifications[id] = notification
+			marked++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return marked, nil
+}
+
+// SetNotificationPreferences merges preferences into the user's settings.
+func (db *DB) SetNotificationPreferences(userID int, preferences map[NotificationType]bool) (User, error) {
+	return db.updateUser(userID, func(user *User) error {
+		if user.NotificationPreferences == nil {
+			user.NotificationPreferences = map[NotificationType]bool{}
+		}
+		for notificationType, enabled := range preferences {
+			user.NotificationPreferences[notificationType] = enabled
+		}
+		return nil
+	})
+}
+
+// nextNotificationID never hands out the id of a deleted notification
+// again.
+func (dbStructure *DBStructure) nextNotificationID() int {
+	id := max(dbStructure.LastNotificationID, len(dbStructure.Notifications))
+	for existing := range dbStructure.Notifications {
+		if existing > id {
+			id = existing
+		}
+	}
+	dbStructure.LastNotificationID = id + 1
+	return dbStructure.LastNotificationID
+}
@@ -0,0 +1,70 @@
+package database
+
+import "time"
+
+type PersonalToken struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	SecretHash string    `json:"secret_hash"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+func (db *DB) CreatePersonalToken(token PersonalToken) (PersonalToken, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.PersonalTokens[token.ID]; ok {
+			return ErrAlreadyExists
+		}
+		dbStructure.PersonalTokens[token.ID] = token
+		return nil
+	})
+	if err != nil {
+		return PersonalToken{}, err
+	}
+	return token, nil
+}
+
+func (db *DB) GetPersonalToken(id string) (PersonalToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return PersonalToken{}, err
+	}
+	token, ok := dbStructure.PersonalTokens[id]
+	if !ok {
+		return PersonalToken{}, ErrNotExist
+	}
+	return token, nil
+}
+
+func (db *DB) GetUserPersonalTokens(userID int) ([]PersonalToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	tokens := []PersonalToken{}
+	for _, token := range dbStructure.PersonalTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// RevokePersonalToken revokes a token owned by userID. Tokens of other
+// users are reported as ErrNotExist.
+func (db *DB) RevokePersonalToken(userID int, id string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		token, ok := dbStructure.PersonalTokens[id]
+		if !ok || token.UserID != userID {
+			return ErrNotExist
+		}
+		if token.RevokedAt.IsZero() {
+			token.RevokedAt = time.Now().UTC()
+		}
+		dbStructure.PersonalTokens[id] = token
+		return nil
+	})
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAlreadyVoted = errors.New("Already voted")
+	ErrPollClosed   = errors.New("Poll is closed")
+)
+
+// Poll is attached to a chirp. Its votes are stored apart from the chirp so
+// that chirps can be returned as they are without revealing the results.
+type Poll struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+func (poll Poll) closed(now time.Time) bool {
+	return !now.Before(poll.ClosesAt)
+}
+
+type PollVote struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	Option    int       `json:"option"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PollResults is a poll as one viewer sees it. Counts is only set once the
+// viewer voted or the poll closed.
+type PollResults struct {
+	ChirpID     int       `json:"chirp_id"`
+	Options     []string  `json:"options"`
+	ClosesAt    time.Time `json:"closes_at"`
+	Closed      bool      `json:"closed"`
+	VotedOption *int      `json:"voted_option,omitempty"`
+	Counts      []int     `json:"counts,omitempty"`
+}
+
+func pollVoteKey(chirpID, userID int) string {
+	return fmt.Sprintf("%d:%d", chirpID, userID)
+}
+
+func (dbStructure *DBStructure) pollResults(chirp Chirp, viewerID int, now time.Time) PollResults {
+	results := PollResults{
+		ChirpID:  chirp.ID,
+		Options:  chirp.Poll.Options,
+		ClosesAt: chirp.Poll.ClosesAt,
+		Closed:   chirp.Poll.closed(now),
+	}
+	if vote, ok := dbStructure.PollVotes[pollVoteKey(chirp.ID, viewerID)]; ok {
+		option := vote.Option
+		results.VotedOption = &option
+	}
+	if results.VotedOption == nil && !results.Closed {
+		return results
+	}
+	results.Counts = make([]int, len(chirp.Poll.Options))
+	for _, vote := range dbStructure.PollVotes {
+		if vote.ChirpID == chirp.ID && vote.Option < len(results.Counts) {
+			results.Counts[vote.Option]++
+		}
+	}
+	return results
+}
+
+// pollChirp returns ErrNotExist unless chirpID is a chirp with a poll that
+// viewerID can see.
+func (dbStructure *DBStructure) pollChirp(chirpID, viewerID int) (Chirp, error) {
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Poll == nil || !chirp.VisibleTo(viewerID) {
+		return Chirp{}, ErrNotExist
+	}
+	return chirp, nil
+}
+
+func (db *DB) GetPollResults(chirpID, viewerID int) (PollResults, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return PollResults{}, err
+	}
+	chirp, err := dbStructure.pollChirp(chirpID, viewerID)
+	if err != nil {
+		return PollResults{}, err
+	}
+	return dbStructure.pollResults(chirp, viewerID, time.Now()), nil
+}
+
+// VotePoll records the users vote and returns the results they can now
+// see. Checking for an earlier vote and storing this one happen in one
+// update, so a user cannot vote twice by voting concurrently.
+func (db *DB) VotePoll(chirpID, userID, option int) (PollResults, error) {
+	results := PollResults{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirp, err := dbStructure.pollChirp(chirpID, userID)
+		if err != nil {
+			return err
+		}
+		// Not even the author votes before a scheduled chirp is published.
+		if chirp.Scheduled {
+			return ErrNotExist
+		}
+		now := time.Now()
+		if chirp.Poll.closed(now) {
+			return ErrPollClosed
+		}
+		if option < 0 || option >= len(chirp.Poll.Options) {
+			return ErrNotExist
+		}
+		if dbStructure.blocks(chirp.AuthorID, userID) {
+			return ErrBlocked
+		}
+		key := pollVoteKey(chirpID, userID)
+		if _, ok := dbStructure.PollVotes[key]; ok {
+			return ErrAlreadyVoted
+		}
+		dbStructure.PollVotes[key] = PollVote{
+			ChirpID:   chirpID,
+			UserID:    userID,
+			Option:    option,
+			CreatedAt: now.UTC(),
+		}
+		results = dbStructure.pollResults(chirp, userID, now)
+		return nil
+	})
+	if err != nil {
+		return PollResults{}, err
+	}
+	return results, nil
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// publishJob returns the pending or running job that publishes chirpID.
+func (dbStructure *DBStructure) publishJob(chirpID int) (Job, bool) {
+	for _, job := range dbStructure.Jobs {
+		if job.Kind == JobPublishChirp && job.SubjectID == chirpID && !job.finished() {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// ScheduleChirp stores chirp as scheduled together with the job that
+// publishes it at publishAt, so neither exists without the other.
+func (db *DB) ScheduleChirp(chirp Chirp, publishAt time.Time) (Chirp, error) {
+	publishAt = publishAt.UTC()
+	err := db.update(func(dbStructure *DBStructure) error {
+		if err := dbStructure.checkChirp(chirp); err != nil {
+			return err
+		}
+		chirp.ID = dbStructure.nextChirpID()
+		chirp.Scheduled = true
+		chirp.PublishAt = &publishAt
+		dbStructure.Chirps[chirp.ID] = chirp
+		dbStructure.addJob(Job{
+			Kind:      JobPublishChirp,
+			SubjectID: chirp.ID,
+			OwnerID:   chirp.AuthorID,
+			RunAt:     publishAt,
+		})
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetScheduledChirps returns the authors scheduled chirps, the next one to
+// be published first.
+func (db *DB) GetScheduledChirps(authorID int) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	chirps := []Chirp{}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.Scheduled && chirp.AuthorID == authorID {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].PublishAt.Before(*chirps[j].PublishAt)
+	})
+	return chirps, nil
+}
+
+// scheduledChirp returns ErrNotExist unless chirpID is a scheduled chirp by
+// authorID, and ErrJobRunning if it is being published right now.
+func (dbStructure *DBStructure) scheduledChirp(authorID, chirpID int) (Chirp, Job, error) {
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || !chirp.Scheduled || chirp.AuthorID != authorID {
+		return Chirp{}, Job{}, ErrNotExist
+	}
+	job, ok := dbStructure.publishJob(chirpID)
+	if ok && job.Status == JobRunning {
+		return Chirp{}, Job{}, ErrJobRunning
+	}
+	return chirp, job, nil
+}
+
+// CancelScheduledChirp deletes a scheduled chirp before it is published.
+func (db *DB) CancelScheduledChirp(authorID, chirpID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		_, job, err := dbStructure.scheduledChirp(authorID, chirpID)
+		if err != nil {
+			return err
+		}
+		delete(dbStructure.Chirps, chirpID)
+		if job.ID != 0 {
+			job.Status = JobCanceled
+			job.FinishedAt = time.Now().UTC()
+			dbStructure.Jobs[job.ID] = job
+		}
+		return nil
+	})
+}
+
+// RescheduleChirp moves the publication of a scheduled chirp. It returns
+// ErrPollClosed if the chirps poll would close before it is published.
+func (db *DB) RescheduleChirp(authorID, chirpID int, publishAt time.Time) (Chirp, error) {
+	publishAt = publishAt.UTC()
+	chirp := Chirp{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, job, err := dbStructure.scheduledChirp(authorID, chirpID)
+		if err != nil {
+			return err
+		}
+		if stored.Poll != nil && stored.Poll.closed(publishAt) {
+			return ErrPollClosed
+		}
+		stored.PublishAt = &publishAt
+		dbStructure.Chirps[chirpID] = stored
+		if job.ID == 0 {
+			job = dbStructure.addJob(Job{
+				Kind:      JobPublishChirp,
+				SubjectID: chirpID,
+				OwnerID:   authorID,
+			})
+		}
+		job.RunAt = publishAt
+		job.Attempts = 0
+		dbStructure.Jobs[job.ID] = job
+		chirp = stored
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// PublishScheduledChirp makes a scheduled chirp public. published is false
+// if it already was, or was canceled, so running the job twice publishes
+// the chirp only once.
+func (db *DB) PublishScheduledChirp(chirpID int) (chirp Chirp, published bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Chirps[chirpID]
+		if !ok || !stored.Scheduled {
+			return nil
+		}
+		stored.Scheduled = false
+		dbStructure.Chirps[chirpID] = stored
+		chirp = stored
+		published = true
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	if published {
+		db.publish(TopicChirpCreated, chirp)
+	}
+	return chirp, published, nil
+}
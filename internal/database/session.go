@@ -0,0 +1,82 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Session is a login. Its refresh token stays valid only as long as the
+// session exists.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// CreateSession also drops the users sessions that have expired.
+func (db *DB) CreateSession(session Session) (Session, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Sessions[session.ID]; ok {
+			return ErrAlreadyExists
+		}
+		now := time.Now().UTC()
+		for id, existing := range dbStructure.Sessions {
+			if existing.UserID == session.UserID && existing.ExpiresAt.Before(now) {
+				delete(dbStructure.Sessions, id)
+			}
+		}
+		session.CreatedAt = now
+		session.LastUsedAt = now
+		dbStructure.Sessions[session.ID] = session
+		return nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// TouchSession records that the session of userID was used. It returns
+// ErrNotExist if the session ended.
+func (db *DB) TouchSession(userID int, id string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		session, ok := dbStructure.Sessions[id]
+		if !ok || session.UserID != userID {
+			return ErrNotExist
+		}
+		session.LastUsedAt = time.Now().UTC()
+		dbStructure.Sessions[id] = session
+		return nil
+	})
+}
+
+// GetSessions returns the users sessions that have not expired, the most
+// recently used first.
+func (db *DB) GetSessions(userID int) ([]Session, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	sessions := []Session{}
+	for _, session := range dbStructure.Sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+func (db *DB) DeleteSession(id string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Sessions, id)
+		return nil
+	})
+}
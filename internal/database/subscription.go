@@ -0,0 +1,185 @@
+package database
+
+import "time"
+
+type Plan string
+
+const (
+	PlanFree      Plan = "free"
+	PlanChirpyRed Plan = "chirpy_red"
+)
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionNone     SubscriptionStatus = "none"
+	SubscriptionActive   SubscriptionStatus = "active"
+	SubscriptionPastDue  SubscriptionStatus = "past_due"
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+	SubscriptionExpired  SubscriptionStatus = "expired"
+)
+
+type SubscriptionEvent struct {
+	Event      string             `json:"event"`
+	Status     SubscriptionStatus `json:"status"`
+	PeriodEnd  time.Time          `json:"period_end"`
+	OccurredAt time.Time          `json:"occurred_at"`
+}
+
+// Subscription tracks a users Chirpy Red membership. User.IsChirpyRed is
+// kept in sync with it so older readers keep working.
+type Subscription struct {
+	Plan             Plan                `json:"plan"`
+	Status           SubscriptionStatus  `json:"status"`
+	CurrentPeriodEnd time.Time           `json:"current_period_end"`
+	GraceUntil       time.Time           `json:"grace_until"`
+	History          []SubscriptionEvent `json:"history"`
+}
+
+// UserPlan returns the plan the user is currently entitled to.
+func (user User) UserPlan() Plan {
+	if user.IsChirpyRed {
+		return PlanChirpyRed
+	}
+	return PlanFree
+}
+
+func (subscription *Subscription) record(event string, now time.Time) {
+	subscription.History = append(subscription.History, SubscriptionEvent{
+		Event:      event,
+		Status:     subscription.Status,
+		PeriodEnd:  subscription.CurrentPeriodEnd,
+		OccurredAt: now,
+	})
+}
+
+func (db *DB) updateSubscription(userID int, event string, fn func(user *User, now time.Time)) (User, error) {
+	return db.updateUser(userID, func(user *User) error {
+		now := time.Now().UTC()
+		if user.Subscription.Plan == "" && user.IsChirpyRed {
+			// Members upgraded before subscriptions were tracked.
+			user.Subscription.Plan = PlanChirpyRed
+			user.Subscription.Status = SubscriptionActive
+		}
+		fn(user, now)
+		user.IsChirpyRed = user.Subscription.Plan == PlanChirpyRed
+		user.Subscription.record(event, now)
+		return nil
+	})
+}
+
+func (db *DB) UpgradeUser(userID int, periodEnd time.Time) (User, error) {
+	return db.updateSubscription(userID, "upgraded", func(user *User, now time.Time) {
+		user.Subscription.Plan = PlanChirpyRed
+		user.Subscription.Status = SubscriptionActive
+		user.Subscription.CurrentPeriodEnd = periodEnd
+		user.Subscription.GraceUntil = time.Time{}
+	})
+}
+
+func (db *DB) RenewSubscription(userID int, periodEnd time.Time) (User, error) {
+	return db.updateSubscription(userID, "renewed", func(user *User, now time.Time) {
+		user.Subscription.Plan = PlanChirpyRed
+		user.Subscription.Status = SubscriptionActive
+		if periodEnd.After(user.Subscription.CurrentPeriodEnd) {
+			user.Subscription.CurrentPeriodEnd = periodEnd
+		}
+		user.Subscription.GraceUntil = time.Time{}
+	})
+}
+
+// CancelSubscription keeps the membership until the end of the paid period.
+func (db *DB) CancelSubscription(userID int) (User, error) {
+	return db.updateSubscription(userID, "canceled", func(user *User, now time.Time) {
+		if user.Subscription.Plan != PlanChirpyRed {
+			return
+		}
+		user.Subscription.Status = SubscriptionCanceled
+		if user.Subscription.CurrentPeriodEnd.Before(now) {
+			user.Subscription.CurrentPeriodEnd = now
+		}
+	})
+}
+
+// MarkPaymentFailed keeps the membership until graceUntil to give the user
+// time to fix their payment.
+func (db *DB) MarkPaymentFailed(userID int, graceUntil time.Time) (User, error) {
+	return db.updateSubscription(userID, "payment_failed", func(user *User, now time.Time) {
+		if user.Subscription.Plan != PlanChirpyRed {
+			return
+		}
+		user.Subscription.Status = SubscriptionPastDue
+		if user.Subscription.GraceUntil.IsZero() || graceUntil.Before(user.Subscription.GraceUntil) {
+			user.Subscription.GraceUntil = graceUntil
+		}
+	})
+}
+
+// GrantChirpyRed makes the user a member without a payment, until
+// periodEnd or, if it is zero, until it is revoked.
+func (db *DB) GrantChirpyRed(userID int, periodEnd time.Time) (User, error) {
+	return db.updateSubscription(userID, "granted", func(user *User, now time.Time) {
+		user.Subscription.Plan = PlanChirpyRed
+		user.Subscription.Status = SubscriptionActive
+		user.Subscription.CurrentPeriodEnd = periodEnd
+		user.Subscription.GraceUntil = time.Time{}
+	})
+}
+
+// RevokeChirpyRed ends the membership immediately, however it was gotten.
+func (db *DB) RevokeChirpyRed(userID int) (User, error) {
+	return db.updateSubscription(userID, "revoked", func(user *User, now time.Time) {
+		user.Subscription.Plan = PlanFree
+		user.Subscription.Status = SubscriptionExpired
+		user.Subscription.GraceUntil = time.Time{}
+	})
+}
+
+// DowngradeUser ends the membership immediately.
+func (db *DB) DowngradeUser(userID int) (User, error) {
+	return db.updateSubscription(userID, "downgraded", func(user *User, now time.Time) {
+		user.Subscription.Plan = PlanFree
+		user.Subscription.Status = SubscriptionExpired
+		user.Subscription.GraceUntil = time.Time{}
+	})
+}
+
+// ExpireLapsedSubscriptions downgrades every member whose paid period, plus
+// grace for active members who were never renewed, or whose payment grace
+// period has passed. It returns the ids of the expired users.
+func (db *DB) ExpireLapsedSubscriptions(now time.Time, grace time.Duration) ([]int, error) {
+	expired := []int{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, user := range dbStructure.Users {
+			subscription := user.Subscription
+			if subscription.Plan != PlanChirpyRed {
+				continue
+			}
+			lapsed := false
+			switch subscription.Status {
+			case SubscriptionActive:
+				lapsed = !subscription.CurrentPeriodEnd.IsZero() &&
+					now.After(subscription.CurrentPeriodEnd.Add(grace))
+			case SubscriptionPastDue:
+				lapsed = now.After(subscription.GraceUntil)
+			case SubscriptionCanceled:
+				lapsed = now.After(subscription.CurrentPeriodEnd)
+			}
+			if !lapsed {
+				continue
+			}
+			user.Subscription.Plan = PlanFree
+			user.Subscription.Status = SubscriptionExpired
+			user.Subscription.GraceUntil = time.Time{}
+			user.Subscription.record("expired", now)
+			user.IsChirpyRed = false
+			dbStructure.Users[id] = user
+			expired = append(expired, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
@@ -24,19 +24,11 @@ func (db *DB) IsTokenRevoked(token string) (bool, error) {
 }
 
 func (db *DB) RevokeToken(tok string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-	revocation := Revocation{
-		Token:     tok,
-		RevokedAt: time.Now().UTC(),
-	}
-	dbStructure.Revocations[tok] = revocation
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-	return nil
+	return db.update(func(dbStructure *DBStructure) error {
+		dbStructure.Revocations[tok] = Revocation{
+			Token:     tok,
+			RevokedAt: time.Now().UTC(),
+		}
+		return nil
+	})
 }
@@ -2,52 +2,124 @@ package database
 
 import (
 	"errors"
+	"strings"
+	"time"
 )
 
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func ParseRole(value string) (Role, bool) {
+	switch role := Role(value); role {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return role, true
+	}
+	return "", false
+}
+
 type User struct {
 	ID          int    `json:"id"`
 	Email       string `json:"email"`
 	Password    string `json:"password"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Role        Role   `json:"role"`
+
+	IsVerified bool      `json:"is_verified"`
+	VerifiedAt time.Time `json:"verified_at"`
+
+	// PendingEmail replaces Email once the token PendingEmailTokenID that
+	// was sent to it is used.
+	PendingEmail        string `json:"pending_email,omitempty"`
+	PendingEmailTokenID string `json:"pending_email_token_id,omitempty"`
+
+	TOTPEnabled       bool     `json:"totp_enabled"`
+	TOTPSecret        string   `json:"totp_secret"`
+	TOTPPendingSecret string   `json:"totp_pending_secret"`
+	TOTPLastStep      int64    `json:"totp_last_step"`
+	RecoveryCodes     []string `json:"recovery_codes"`
+
+	Subscription Subscription `json:"subscription"`
+
+	// SuspendedAt is set while an admin has suspended the user.
+	SuspendedAt     *time.Time `json:"suspended_at,omitempty"`
+	SuspendedReason string     `json:"suspended_reason,omitempty"`
+	// PasswordResetRequired locks the user out until they reset their
+	// password.
+	PasswordResetRequired bool `json:"password_reset_required,omitempty"`
+
+	// NotificationPreferences turns notification types off. Types that are
+	// not listed are on.
+	NotificationPreferences map[NotificationType]bool `json:"notification_preferences"`
 }
 
-var ErrAlreadyExists = errors.New("User already exists")
+var (
+	ErrAlreadyExists = errors.New("User already exists")
+	ErrCodeReused    = errors.New("Code has already been used")
+)
 
-func (db *DB) DoesUserExist(email string) (bool, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return false, nil
-	}
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return true, nil
+// emailKey is how emails are compared: two emails that only differ in case
+// belong to the same mailbox.
+func emailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// buildEmailIndex indexes the users of database files from before the
+// index. Should two emails only differ in case, the older user keeps it.
+func (dbStructure *DBStructure) buildEmailIndex() {
+	dbStructure.UserEmails = map[string]int{}
+	for id, user := range dbStructure.Users {
+		key := emailKey(user.Email)
+		if existing, ok := dbStructure.UserEmails[key]; ok && existing < id {
+			continue
 		}
+		dbStructure.UserEmails[key] = id
 	}
-	return false, nil
 }
 
-func (db *DB) CreateUser(email, password string) (User, error) {
-	if _, err := db.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
-		return User{}, ErrAlreadyExists
-	}
+// emailTaken reports whether email belongs to a user other than userID.
+func (dbStructure *DBStructure) emailTaken(email string, userID int) bool {
+	id, ok := dbStructure.UserEmails[emailKey(email)]
+	return ok && id != userID
+}
 
+func (db *DB) DoesUserExist(email string) (bool, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
-		return User{}, err
-	}
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:          id,
-		Email:       email,
-		Password:    password,
-		IsChirpyRed: false,
+		return false, nil
 	}
-	dbStructure.Users[id] = user
-	err = db.writeDB(dbStructure)
+	_, ok := dbStructure.UserEmails[emailKey(email)]
+	return ok, nil
+}
+
+func (db *DB) CreateUser(email, password string) (User, error) {
+	user := User{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.emailTaken(email, 0) {
+			return ErrAlreadyExists
+		}
+		user = User{
+			ID:          dbStructure.nextUserID(),
+			Email:       email,
+			Password:    password,
+			IsChirpyRed: false,
+			Role:        RoleUser,
+			Subscription: Subscription{
+				Plan:   PlanFree,
+				Status: SubscriptionNone,
+			},
+		}
+		dbStructure.Users[user.ID] = user
+		dbStructure.UserEmails[emailKey(email)] = user.ID
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
-
 	return user, nil
 }
 
@@ -69,47 +141,185 @@ func (db *DB) GetUserByEmail(email string) (User, error) {
 	if err != nil {
 		return User{}, nil
 	}
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return user, nil
-		}
+	user, ok := dbStructure.Users[dbStructure.UserEmails[emailKey(email)]]
+	if !ok {
+		return User{}, ErrNotExist
 	}
-	return User{}, ErrNotExist
+	return user, nil
 }
 
-func (db *DB) UpdateUser(userID int, email, password string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-	user, ok := dbStructure.Users[userID]
-	if !ok {
-		return User{}, ErrNotExist
+// CredentialChange is what UpdateCredentials changes. Empty fields are
+// left alone.
+type CredentialChange struct {
+	Password string
+	// PendingEmail waits for the token PendingEmailTokenID that was sent
+	// to it before it replaces the users email.
+	PendingEmail        string
+	PendingEmailTokenID string
+}
+
+// UpdateCredentials applies every part of change or, if one cant be, none
+// of them. It returns ErrAlreadyExists if another user has the pending
+// email.
+func (db *DB) UpdateCredentials(userID int, change CredentialChange) (User, error) {
+	return db.updateUserStructure(userID, func(dbStructure *DBStructure, user *User) error {
+		if change.PendingEmail != "" {
+			if dbStructure.emailTaken(change.PendingEmail, userID) {
+				return ErrAlreadyExists
+			}
+			user.PendingEmail = change.PendingEmail
+			user.PendingEmailTokenID = change.PendingEmailTokenID
+		}
+		if change.Password != "" {
+			user.Password = change.Password
+		}
+		return nil
+	})
+}
+
+// ConfirmEmailChange makes the pending email the users email, which is
+// verified by using the token. Only the token of the latest request is
+// accepted; for older ones it returns ErrNotExist. It returns
+// ErrAlreadyExists if another user took the email in the meantime.
+func (db *DB) ConfirmEmailChange(userID int, tokenID string) (User, error) {
+	return db.updateUserStructure(userID, func(dbStructure *DBStructure, user *User) error {
+		if user.PendingEmail == "" || user.PendingEmailTokenID != tokenID {
+			return ErrNotExist
+		}
+		if dbStructure.emailTaken(user.PendingEmail, userID) {
+			return ErrAlreadyExists
+		}
+		delete(dbStructure.UserEmails, emailKey(user.Email))
+		dbStructure.UserEmails[emailKey(user.PendingEmail)] = userID
+		user.Email = user.PendingEmail
+		user.PendingEmail = ""
+		user.PendingEmailTokenID = ""
+		if !user.IsVerified {
+			user.IsVerified = true
+			user.VerifiedAt = time.Now().UTC()
+		}
+		return nil
+	})
+}
+
+func (db *DB) UpdateUserPassword(userID int, password string) error {
+	_, err := db.updateUser(userID, func(user *User) error {
+		user.Password = password
+		return nil
+	})
+	return err
+}
+
+func (db *DB) VerifyUser(userID int) (User, error) {
+	return db.updateUser(userID, func(user *User) error {
+		if !user.IsVerified {
+			user.IsVerified = true
+			user.VerifiedAt = time.Now().UTC()
+		}
+		return nil
+	})
+}
+
+// UserRole returns the users role, treating users stored before roles
+// existed as regular users.
+func (user User) UserRole() Role {
+	if user.Role == "" {
+		return RoleUser
 	}
-	user.Email = email
-	user.Password = password
-	dbStructure.Users[userID] = user
-	err = db.writeDB(dbStructure)
+	return user.Role
+}
+
+func (db *DB) SetUserRole(userID int, role Role) (User, error) {
+	return db.updateUser(userID, func(user *User) error {
+		user.Role = role
+		return nil
+	})
+}
+
+// updateUser applies fn to the stored user and returns the updated copy.
+func (db *DB) updateUser(userID int, fn func(user *User) error) (User, error) {
+	return db.updateUserStructure(userID, func(dbStructure *DBStructure, user *User) error {
+		return fn(user)
+	})
+}
+
+// updateUserStructure is updateUser for changes that also touch the rest of
+// the database, such as the email index.
+func (db *DB) updateUserStructure(userID int, fn func(dbStructure *DBStructure, user *User) error) (User, error) {
+	user := User{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		if err := fn(dbStructure, &stored); err != nil {
+			return err
+		}
+		dbStructure.Users[userID] = stored
+		user = stored
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 	return user, nil
 }
 
-func (db *DB) UpgradeUser(userID int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-	user, ok := dbStructure.Users[userID]
-	if !ok {
+func (db *DB) SetPendingTOTP(userID int, secret string) error {
+	_, err := db.updateUser(userID, func(user *User) error {
+		user.TOTPPendingSecret = secret
+		return nil
+	})
+	return err
+}
+
+// EnableTOTP promotes the pending secret, stores the hashed recovery codes
+// and records step as used so the confirming code cant be replayed.
+func (db *DB) EnableTOTP(userID int, step int64, recoveryCodeHashes []string) (User, error) {
+	return db.updateUser(userID, func(user *User) error {
+		user.TOTPSecret = user.TOTPPendingSecret
+		user.TOTPPendingSecret = ""
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodes = recoveryCodeHashes
+		return nil
+	})
+}
+
+func (db *DB) DisableTOTP(userID int) (User, error) {
+	return db.updateUser(userID, func(user *User) error {
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPPendingSecret = ""
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+		return nil
+	})
+}
+
+// UseTOTPStep rejects a step that is not newer than the last accepted one.
+func (db *DB) UseTOTPStep(userID int, step int64) error {
+	_, err := db.updateUser(userID, func(user *User) error {
+		if step <= user.TOTPLastStep {
+			return ErrCodeReused
+		}
+		user.TOTPLastStep = step
+		return nil
+	})
+	return err
+}
+
+// UseRecoveryCode removes the recovery code hash, returning ErrNotExist if
+// it is not one of the users remaining codes.
+func (db *DB) UseRecoveryCode(userID int, codeHash string) error {
+	_, err := db.updateUser(userID, func(user *User) error {
+		for i, stored := range user.RecoveryCodes {
+			if stored == codeHash {
+				user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
 		return ErrNotExist
-	}
-	user.IsChirpyRed = true
-	dbStructure.Users[userID] = user
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-	return nil
+	})
+	return err
 }
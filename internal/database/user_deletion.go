@@ -0,0 +1,189 @@
+package database
+
+import "time"
+
+// DeletedUser is what is left to clean up outside the database after a
+// user was deleted.
+type DeletedUser struct {
+	User User
+	// Chirps are the published chirps that were deleted.
+	Chirps []Chirp
+	// ExportFiles are the users data exports that were not downloaded.
+	ExportFiles []string
+}
+
+// nextUserID never hands out the id of a deleted user again, so tokens
+// issued to them cant be used by someone else.
+func (dbStructure *DBStructure) nextUserID() int {
+	id := 0
+	for existing := range dbStructure.Users {
+		if existing > id {
+			id = existing
+		}
+	}
+	for existing := range dbStructure.DeletedUsers {
+		if existing > id {
+			id = existing
+		}
+	}
+	return id + 1
+}
+
+// DeleteUser removes the user and everything that belongs to them in one
+// update. Their chirps and direct messages are deleted, or kept without an
+// author if anonymize is set; either way their ids stay taken so replies
+// and read receipts keep pointing at the right thing.
+func (db *DB) DeleteUser(userID int, anonymize bool) (DeletedUser, error) {
+	deleted := DeletedUser{Chirps: []Chirp{}, ExportFiles: []string{}}
+	err := db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		now := time.Now().UTC()
+		deleted.User = user
+		delete(dbStructure.Users, userID)
+		if dbStructure.UserEmails[emailKey(user.Email)] == userID {
+			delete(dbStructure.UserEmails, emailKey(user.Email))
+		}
+		dbStructure.DeletedUsers[userID] = now
+
+		removedChirps := map[int]struct{}{}
+		for id, chirp := range dbStructure.Chirps {
+			if chirp.AuthorID != userID {
+				continue
+			}
+			if anonymize && !chirp.Scheduled {
+				chirp.AuthorID = 0
+				chirp.MentionIDs = nil
+				dbStructure.Chirps[id] = chirp
+				continue
+			}
+			delete(dbStructure.Chirps, id)
+			removedChirps[id] = struct{}{}
+			if !chirp.Scheduled {
+				deleted.Chirps = append(deleted.Chirps, chirp)
+			}
+		}
+		for key, vote := range dbStructure.PollVotes {
+			if _, ok := removedChirps[vote.ChirpID]; ok || vote.UserID == userID {
+				delete(dbStructure.PollVotes, key)
+			}
+		}
+		for id, bookmark := range dbStructure.Bookmarks {
+			if _, ok := removedChirps[bookmark.ChirpID]; ok || bookmark.UserID == userID {
+				delete(dbStructure.Bookmarks, id)
+			}
+		}
+		for id, job := range dbStructure.Jobs {
+			if job.OwnerID == userID && !job.finished() && job.Status != JobRunning {
+				job.Status = JobCanceled
+				job.FinishedAt = now
+				dbStructure.Jobs[id] = job
+			}
+		}
+
+		for id, draft := range dbStructure.Drafts {
+			if draft.AuthorID == userID {
+				delete(dbStructure.Drafts, id)
+			}
+		}
+		for id, list := range dbStructure.Lists {
+			if list.OwnerID == userID {
+				delete(dbStructure.Lists, id)
+				continue
+			}
+			if list.hasMember(userID) {
+				members := make([]int, 0, len(list.MemberIDs))
+				for _, memberID := range list.MemberIDs {
+					if memberID != userID {
+						members = append(members, memberID)
+					}
+				}
+				list.MemberIDs = members
+				dbStructure.Lists[id] = list
+			}
+		}
+		for key, follow := range dbStructure.Follows {
+			if follow.FollowerID == userID || follow.FolloweeID == userID {
+				delete(dbStructure.Follows, key)
+			}
+		}
+		for key, block := range dbStructure.Blocks {
+			if block.BlockerID == userID || block.BlockedID == userID {
+				delete(dbStructure.Blocks, key)
+			}
+		}
+		for key, mute := range dbStructure.Mutes {
+			if mute.MuterID == userID || mute.MutedID == userID {
+				delete(dbStructure.Mutes, key)
+			}
+		}
+		for id, notification := range dbStructure.Notifications {
+			if notification.UserID == userID {
+				delete(dbStructure.Notifications, id)
+			} else if notification.ActorID == userID {
+				notification.ActorID = 0
+				dbStructure.Notifications[id] = notification
+			}
+		}
+
+		for id, conversation := range dbStructure.Conversations {
+			if !conversation.HasParticipant(userID) {
+				continue
+			}
+			participants := make([]int, 0, len(conversation.ParticipantIDs))
+			for _, participantID := range conversation.ParticipantIDs {
+				if participantID != userID {
+					participants = append(participants, participantID)
+				}
+			}
+			conversation.ParticipantIDs = participants
+			delete(conversation.ReadUpTo, userID)
+			dbStructure.Conversations[id] = conversation
+		}
+		for id, message := range dbStructure.Messages {
+			if message.SenderID != userID {
+				continue
+			}
+			message.SenderID = 0
+			if !anonymize {
+				message.Body = ""
+			}
+			dbStructure.Messages[id] = message
+		}
+
+		dbStructure.endSessions(userID)
+		for id, token := range dbStructure.PersonalTokens {
+			if token.UserID == userID {
+				delete(dbStructure.PersonalTokens, id)
+			}
+		}
+		for key, identity := range dbStructure.Identities {
+			if identity.UserID == userID {
+				delete(dbStructure.Identities, key)
+			}
+		}
+		for id, endpoint := range dbStructure.WebhookEndpoints {
+			if endpoint.OwnerID == userID {
+				dbStructure.deleteWebhookEndpoint(id)
+			}
+		}
+		for id, export := range dbStructure.Exports {
+			if export.UserID == userID {
+				if export.File != "" {
+					deleted.ExportFiles = append(deleted.ExportFiles, export.File)
+				}
+				delete(dbStructure.Exports, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return DeletedUser{}, err
+	}
+	for _, chirp := range deleted.Chirps {
+		db.publish(TopicChirpDeleted, chirp)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+type WebhookOutcome string
+
+const (
+	WebhookProcessed WebhookOutcome = "processed"
+	WebhookIgnored   WebhookOutcome = "ignored"
+	WebhookDuplicate WebhookOutcome = "duplicate"
+	WebhookRejected  WebhookOutcome = "rejected"
+	WebhookFailed    WebhookOutcome = "failed"
+)
+
+// WebhookDelivery records an incoming webhook request and what came of it.
+type WebhookDelivery struct {
+	ID         int            `json:"id"`
+	Source     string         `json:"source"`
+	EventID    string         `json:"event_id"`
+	Event      string         `json:"event"`
+	Outcome    WebhookOutcome `json:"outcome"`
+	HTTPStatus int            `json:"http_status"`
+	Error      string         `json:"error,omitempty"`
+	Payload    string         `json:"payload"`
+	ReceivedAt time.Time      `json:"received_at"`
+}
+
+func (dbStructure *DBStructure) nextWebhookDeliveryID() int {
+	id := max(dbStructure.LastWebhookDeliveryID, len(dbStructure.WebhookDeliveries))
+	for existing := range dbStructure.WebhookDeliveries {
+		if existing > id {
+			id = existing
+		}
+	}
+	dbStructure.LastWebhookDeliveryID = id + 1
+	return dbStructure.LastWebhookDeliveryID
+}
+
+// CreateWebhookDelivery logs delivery and drops the oldest deliveries
+// beyond the newest keep, so anyone able to reach the endpoint cant grow
+// the database without bound. A keep of zero or less keeps everything.
+func (db *DB) CreateWebhookDelivery(delivery WebhookDelivery, keep int) (WebhookDelivery, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		delivery.ID = dbStructure.nextWebhookDeliveryID()
+		dbStructure.WebhookDeliveries[delivery.ID] = delivery
+		if keep <= 0 {
+			return nil
+		}
+		for id := range dbStructure.WebhookDeliveries {
+			if id <= delivery.ID-keep {
+				delete(dbStructure.WebhookDeliveries, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns the newest deliveries first, optionally only
+// those from source.
+func (db *DB) GetWebhookDeliveries(source string, limit int) ([]WebhookDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range dbStructure.WebhookDeliveries {
+		if source != "" && delivery.Source != source {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func webhookEventKey(source, eventID string) string {
+	return source + ":" + eventID
+}
+
+// ClaimWebhookEvent marks an event as processed. It returns
+// ErrAlreadyExists if the event was claimed before, so each event is only
+// handled once.
+func (db *DB) ClaimWebhookEvent(source, eventID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		key := webhookEventKey(source, eventID)
+		if _, ok := dbStructure.ProcessedWebhookEvents[key]; ok {
+			return ErrAlreadyExists
+		}
+		dbStructure.ProcessedWebhookEvents[key] = time.Now().UTC()
+		return nil
+	})
+}
+
+// ReleaseWebhookEvent undoes ClaimWebhookEvent when handling the event
+// failed, so a redelivery is processed again.
+func (db *DB) ReleaseWebhookEvent(source, eventID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.ProcessedWebhookEvents, webhookEventKey(source, eventID))
+		return nil
+	})
+}
@@ -0,0 +1,276 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrDeliveryNotDead = errors.New("Delivery is not dead")
+
+// WebhookEndpoint is a receiver registered for outgoing webhooks. Global
+// endpoints are registered by admins and receive events about every user.
+type WebhookEndpoint struct {
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	Global    bool      `json:"global"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OutgoingStatus string
+
+const (
+	OutgoingPending   OutgoingStatus = "pending"
+	OutgoingDelivered OutgoingStatus = "delivered"
+	OutgoingDead      OutgoingStatus = "dead"
+)
+
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// OutgoingDelivery is one event queued for one endpoint, together with the
+// log of every attempt to deliver it.
+type OutgoingDelivery struct {
+	ID            int               `json:"id"`
+	EndpointID    int               `json:"endpoint_id"`
+	Event         string            `json:"event"`
+	Payload       string            `json:"payload"`
+	Status        OutgoingStatus    `json:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+func (db *DB) CreateWebhookEndpoint(endpoint WebhookEndpoint) (WebhookEndpoint, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		endpoint.ID = dbStructure.nextWebhookEndpointID()
+		dbStructure.WebhookEndpoints[endpoint.ID] = endpoint
+		return nil
+	})
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	return endpoint, nil
+}
+
+func (dbStructure *DBStructure) nextWebhookEndpointID() int {
+	id := max(dbStructure.LastWebhookEndpointID, len(dbStructure.WebhookEndpoints))
+	for existing := range dbStructure.WebhookEndpoints {
+		if existing > id {
+			id = existing
+		}
+	}
+	dbStructure.LastWebhookEndpointID = id + 1
+	return dbStructure.LastWebhookEndpointID
+}
+
+func (dbStructure *DBStructure) nextOutgoingDeliveryID() int {
+	id := max(dbStructure.LastOutgoingDeliveryID, len(dbStructure.OutgoingDeliveries))
+	for existing := range dbStructure.OutgoingDeliveries {
+		if existing > id {
+			id = existing
+		}
+	}
+	dbStructure.LastOutgoingDeliveryID = id + 1
+	return dbStructure.LastOutgoingDeliveryID
+}
+
+// deleteWebhookEndpoint deletes the endpoint together with every delivery
+// to it, since their payloads are about the endpoints owner.
+func (dbStructure *DBStructure) deleteWebhookEndpoint(id int) {
+	delete(dbStructure.WebhookEndpoints, id)
+	for deliveryID, delivery := range dbStructure.OutgoingDeliveries {
+		if delivery.EndpointID == id {
+			delete(dbStructure.OutgoingDeliveries, deliveryID)
+		}
+	}
+}
+
+func (db *DB) GetWebhookEndpoint(id int) (WebhookEndpoint, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	endpoint, ok := dbStructure.WebhookEndpoints[id]
+	if !ok {
+		return WebhookEndpoint{}, ErrNotExist
+	}
+	return endpoint, nil
+}
+
+// GetWebhookEndpoints returns the endpoints owned by ownerID, or only the
+// global endpoints if global is set.
+func (db *DB) GetWebhookEndpoints(ownerID int, global bool) ([]WebhookEndpoint, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := []WebhookEndpoint{}
+	for _, endpoint := range dbStructure.WebhookEndpoints {
+		if global && endpoint.Global || !global && !endpoint.Global && endpoint.OwnerID == ownerID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].ID < endpoints[j].ID
+	})
+	return endpoints, nil
+}
+
+func (db *DB) DeleteWebhookEndpoint(id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.WebhookEndpoints[id]; !ok {
+			return ErrNotExist
+		}
+		dbStructure.deleteWebhookEndpoint(id)
+		return nil
+	})
+}
+
+// EnqueueWebhookEvent queues payload for every endpoint subscribed to event
+// that is global or owned by subjectUserID.
+func (db *DB) EnqueueWebhookEvent(event string, subjectUserID int, payload func(deliveryID int) (string, error)) (int, error) {
+	queued := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for _, endpoint := range dbStructure.WebhookEndpoints {
+			if !endpoint.Global && endpoint.OwnerID != subjectUserID {
+				continue
+			}
+			if !endpoint.wants(event) {
+				continue
+			}
+			id := dbStructure.nextOutgoingDeliveryID()
+			body, err := payload(id)
+			if err != nil {
+				return err
+			}
+			dbStructure.OutgoingDeliveries[id] = OutgoingDelivery{
+				ID:            id,
+				EndpointID:    endpoint.ID,
+				Event:         event,
+				Payload:       body,
+				Status:        OutgoingPending,
+				Attempts:      []DeliveryAttempt{},
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			}
+			queued++
+		}
+		return nil
+	})
+	return queued, err
+}
+
+func (endpoint WebhookEndpoint) wants(event string) bool {
+	for _, filter := range endpoint.Events {
+		if filter == "*" || filter == event {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDueDeliveries returns pending deliveries whose next attempt is due,
+// oldest first.
+func (db *DB) GetDueDeliveries(now time.Time, limit int) ([]OutgoingDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	due := []OutgoingDelivery{}
+	for _, delivery := range dbStructure.OutgoingDeliveries {
+		if delivery.Status == OutgoingPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// RecordDeliveryAttempt appends attempt to the delivery log and moves the
+// delivery to status, scheduling the next attempt at nextAttemptAt.
+func (db *DB) RecordDeliveryAttempt(id int, attempt DeliveryAttempt, status OutgoingStatus, nextAttemptAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delivery, ok := dbStructure.OutgoingDeliveries[id]
+		if !ok {
+			return ErrNotExist
+		}
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Status = status
+		delivery.NextAttemptAt = nextAttemptAt
+		dbStructure.OutgoingDeliveries[id] = delivery
+		return nil
+	})
+}
+
+func (db *DB) GetOutgoingDelivery(id int) (OutgoingDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return OutgoingDelivery{}, err
+	}
+	delivery, ok := dbStructure.OutgoingDeliveries[id]
+	if !ok {
+		return OutgoingDelivery{}, ErrNotExist
+	}
+	return delivery, nil
+}
+
+// GetOutgoingDeliveries returns the newest deliveries of the endpoints,
+// optionally only those with status.
+func (db *DB) GetOutgoingDeliveries(endpointIDs []int, status OutgoingStatus) ([]OutgoingDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[int]struct{}{}
+	for _, id := range endpointIDs {
+		wanted[id] = struct{}{}
+	}
+	deliveries := []OutgoingDelivery{}
+	for _, delivery := range dbStructure.OutgoingDeliveries {
+		if _, ok := wanted[delivery.EndpointID]; !ok {
+			continue
+		}
+		if status != "" && delivery.Status != status {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	return deliveries, nil
+}
+
+// RequeueDelivery moves a dead delivery back into the queue. It returns
+// ErrDeliveryNotDead for deliveries that are still pending or delivered.
+func (db *DB) RequeueDelivery(id int) (OutgoingDelivery, error) {
+	delivery := OutgoingDelivery{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.OutgoingDeliveries[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if stored.Status != OutgoingDead {
+			return ErrDeliveryNotDead
+		}
+		stored.Status = OutgoingPending
+		stored.NextAttemptAt = time.Now().UTC()
+		dbStructure.OutgoingDeliveries[id] = stored
+		delivery = stored
+		return nil
+	})
+	return delivery, err
+}
@@ -0,0 +1,91 @@
+package entitlements
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Entitlements are the limits and features a plan unlocks.
+type Entitlements struct {
+	MaxChirpLength     int  `json:"max_chirp_length"`
+	RateLimitPerMinute int  `json:"rate_limit_per_minute"`
+	CanScheduleChirps  bool `json:"can_schedule_chirps"`
+	MaxDrafts          int  `json:"max_drafts"`
+}
+
+// validate rejects limits that would silently block every chirp or draft,
+// such as one left out of the file.
+func (e Entitlements) validate() error {
+	if e.MaxChirpLength <= 0 {
+		return errors.New("max_chirp_length must be positive")
+	}
+	if e.RateLimitPerMinute <= 0 {
+		return errors.New("rate_limit_per_minute must be positive")
+	}
+	if e.MaxDrafts <= 0 {
+		return errors.New("max_drafts must be positive")
+	}
+	return nil
+}
+
+// Store holds the entitlements of every plan as read from a JSON file
+// mapping plan names to Entitlements. The file must define DefaultPlan,
+// which is also used for unknown plans.
+type Store struct {
+	path        string
+	defaultPlan string
+
+	mu    sync.RWMutex
+	plans map[string]Entitlements
+}
+
+func Load(path, defaultPlan string) (*Store, error) {
+	store := &Store{
+		path:        path,
+		defaultPlan: defaultPlan,
+	}
+	err := store.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload rereads the file. The previous entitlements stay in place if the
+// file is invalid.
+func (s *Store) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	plans := map[string]Entitlements{}
+	err = json.Unmarshal(data, &plans)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	if _, ok := plans[s.defaultPlan]; !ok {
+		return fmt.Errorf("%s has no entitlements for plan %q", s.path, s.defaultPlan)
+	}
+	for plan, entitlements := range plans {
+		if err := entitlements.validate(); err != nil {
+			return fmt.Errorf("%s plan %q: %w", s.path, plan, err)
+		}
+	}
+	s.mu.Lock()
+	s.plans = plans
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Store) For(plan string) Entitlements {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entitlements, ok := s.plans[plan]
+	if !ok {
+		return s.plans[s.defaultPlan]
+	}
+	return entitlements
+}
@@ -0,0 +1,55 @@
+package entitlements
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			file: `{"free": {"max_chirp_length": 140, "rate_limit_per_minute": 10, "max_drafts": 10}}`,
+		},
+		{
+			name:    "missing default plan",
+			file:    `{"chirpy_red": {"max_chirp_length": 500, "rate_limit_per_minute": 60, "max_drafts": 100}}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing chirp length",
+			file:    `{"free": {"rate_limit_per_minute": 10, "max_drafts": 10}}`,
+			wantErr: true,
+		},
+		{
+			name: "zero rate limit on another plan",
+			file: `{"free": {"max_chirp_length": 140, "rate_limit_per_minute": 10, "max_drafts": 10},
+				"chirpy_red": {"max_chirp_length": 500, "rate_limit_per_minute": 0, "max_drafts": 100}}`,
+			wantErr: true,
+		},
+		{
+			name:    "negative drafts",
+			file:    `{"free": {"max_chirp_length": 140, "rate_limit_per_minute": 10, "max_drafts": -1}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "entitlements.json")
+		if err := os.WriteFile(path, []byte(c.file), 0o600); err != nil {
+			t.Fatalf("Couldnt write file: %s", err)
+		}
+		_, err := Load(path, "free")
+		if c.wantErr && err == nil {
+			t.Errorf("%s: should fail to load", c.name)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%s: Error: %s", c.name, err.Error())
+		}
+	}
+}
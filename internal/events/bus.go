@@ -0,0 +1,166 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Event struct {
+	ID    int64       `json:"id"`
+	Topic string      `json:"topic"`
+	At    time.Time   `json:"at"`
+	Data  interface{} `json:"data"`
+	// Recipient is set for events that only concern one user, such as
+	// notifications. Zero means the event is public.
+	Recipient int `json:"-"`
+}
+
+// Broker is the publish/subscribe interface the rest of Chirpy depends on.
+// Bus implements it in-process; a multi-instance deployment can provide an
+// implementation backed by an external broker.
+type Broker interface {
+	Publish(topic string, data interface{})
+	PublishTo(recipient int, topic string, data interface{})
+	Subscribe(buffer int) *Subscription
+	// Since returns the events after id it still knows about. complete is
+	// false if it cant tell what happened after id.
+	Since(id int64) (events []Event, complete bool)
+}
+
+// Subscription receives published events on C. If the subscriber falls so
+// far behind that its buffer fills up, it is dropped: C is closed and
+// Dropped reports true. The subscriber can resume with Broker.Since.
+type Subscription struct {
+	C chan Event
+
+	unsubscribe func(*Subscription)
+	mu          sync.Mutex
+	dropped     bool
+	closed      bool
+}
+
+// NewSubscription is for Broker implementations. unsubscribe must stop
+// delivery to the subscription and then call Terminate.
+func NewSubscription(buffer int, unsubscribe func(*Subscription)) *Subscription {
+	return &Subscription{
+		C:           make(chan Event, buffer),
+		unsubscribe: unsubscribe,
+	}
+}
+
+// Terminate closes C, marking the subscription as dropped if it was cut
+// off for falling behind.
+func (s *Subscription) Terminate(dropped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.dropped = dropped
+	close(s.C)
+}
+
+func (s *Subscription) Dropped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *Subscription) Close() {
+	s.unsubscribe(s)
+}
+
+// Bus is an in-process publish/subscribe hub that keeps the most recent
+// events so reconnecting subscribers can catch up.
+type Bus struct {
+	mu          sync.Mutex
+	nextID      int64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+func NewBus(historySize int) *Bus {
+	return &Bus{
+		nextID:      1,
+		historySize: historySize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish never blocks: subscribers whose buffer is full are dropped.
+func (b *Bus) Publish(topic string, data interface{}) {
+	b.PublishTo(0, topic, data)
+}
+
+func (b *Bus) PublishTo(recipient int, topic string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{
+		ID:        b.nextID,
+		Topic:     topic,
+		At:        time.Now().UTC(),
+		Data:      data,
+		Recipient: recipient,
+	}
+	b.nextID++
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.C <- event:
+		default:
+			delete(b.subscribers, sub)
+			sub.Terminate(true)
+		}
+	}
+}
+
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	sub := NewSubscription(buffer, b.unsubscribe)
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Since returns the kept events after id. complete is false if events after
+// id have already been discarded from the history, or if id was never
+// handed out, as happens when a client resumes after a restart started the
+// ids over.
+func (b *Bus) Since(id int64) (events []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id > b.nextID-1 {
+		return nil, false
+	}
+	if id == b.nextID-1 {
+		return nil, true
+	}
+	complete = len(b.history) > 0 && b.history[0].ID <= id+1
+	for _, event := range b.history {
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+	return events, complete
+}
+
+// LastID returns the id of the most recently published event.
+func (b *Bus) LastID() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID - 1
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+	sub.Terminate(false)
+}
@@ -0,0 +1,37 @@
+package events
+
+import "testing"
+
+func TestBusSince(t *testing.T) {
+	bus := NewBus(2)
+	for i := 0; i < 3; i++ {
+		bus.Publish("chirp.created", i)
+	}
+
+	cases := []struct {
+		name     string
+		id       int64
+		ids      []int64
+		complete bool
+	}{
+		{name: "up to date", id: 3, complete: true},
+		{name: "in history", id: 1, ids: []int64{2, 3}, complete: true},
+		{name: "discarded", id: 0, ids: []int64{2, 3}, complete: false},
+		{name: "from before a restart", id: 10, complete: false},
+	}
+	for _, c := range cases {
+		events, complete := bus.Since(c.id)
+		if complete != c.complete {
+			t.Errorf("%s: complete should be %v not %v", c.name, c.complete, complete)
+		}
+		if len(events) != len(c.ids) {
+			t.Errorf("%s: should return %d events not %d", c.name, len(c.ids), len(events))
+			continue
+		}
+		for i, event := range events {
+			if event.ID != c.ids[i] {
+				t.Errorf("%s: event %d should have id %d not %d", c.name, i, c.ids[i], event.ID)
+			}
+		}
+	}
+}
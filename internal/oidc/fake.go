@@ -0,0 +1,213 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type FakeUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type fakeGrant struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	user          FakeUser
+	expiresAt     time.Time
+}
+
+// FakeProvider is an in-process OpenID Connect provider for development and
+// integration tests. It approves every authorization request as User, or as
+// the email passed in login_hint, without showing a login page.
+type FakeProvider struct {
+	Issuer   string
+	ClientID string
+	User     FakeUser
+
+	key   *rsa.PrivateKey
+	kid   string
+	mu    sync.Mutex
+	codes map[string]fakeGrant
+}
+
+func NewFakeProvider(issuer, clientID string) (*FakeProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomString(8)
+	if err != nil {
+		return nil, err
+	}
+	return &FakeProvider{
+		Issuer:   strings.TrimSuffix(issuer, "/"),
+		ClientID: clientID,
+		User: FakeUser{
+			Subject:       "fake-user",
+			Email:         "fake-user@example.com",
+			EmailVerified: true,
+		},
+		key:   key,
+		kid:   kid,
+		codes: map[string]fakeGrant{},
+	}, nil
+}
+
+func (f *FakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, mustPath(f.Issuer))
+	switch path {
+	case "/.well-known/openid-configuration":
+		f.handleDiscovery(w, r)
+	case "/authorize":
+		f.handleAuthorize(w, r)
+	case "/token":
+		f.handleToken(w, r)
+	case "/jwks":
+		f.handleJWKS(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func mustPath(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Path
+}
+
+func (f *FakeProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, discovery{
+		Issuer:                f.Issuer,
+		AuthorizationEndpoint: f.Issuer + "/authorize",
+		TokenEndpoint:         f.Issuer + "/token",
+		JWKSURI:               f.Issuer + "/jwks",
+	})
+}
+
+func (f *FakeProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != f.ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	user := f.User
+	if hint := query.Get("login_hint"); hint != "" {
+		user = FakeUser{Subject: "fake-" + hint, Email: hint, EmailVerified: true}
+	}
+	code, err := randomString(24)
+	if err != nil {
+		http.Error(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	f.mu.Lock()
+	f.codes[code] = fakeGrant{
+		clientID:      f.ClientID,
+		redirectURI:   redirectURI.String(),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		user:          user,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	f.mu.Unlock()
+
+	values := redirectURI.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirectURI.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (f *FakeProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	f.mu.Lock()
+	grant, ok := f.codes[code]
+	delete(f.codes, code)
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if !ok ||
+		time.Now().After(grant.expiresAt) ||
+		r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("client_id") != grant.clientID ||
+		r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		challenge != grant.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    f.Issuer,
+			Subject:   grant.user.Subject,
+			Audience:  jwt.ClaimStrings{grant.clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+		Nonce:         grant.nonce,
+		Email:         grant.user.Email,
+		EmailVerified: grant.user.EmailVerified,
+	})
+	token.Header["kid"] = f.kid
+	idToken, err := token.SignedString(f.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": idToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (f *FakeProvider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := f.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: f.kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
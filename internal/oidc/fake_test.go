@@ -0,0 +1,109 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newFakeFlow serves a FakeProvider and a callback endpoint over HTTP and
+// returns a Provider for it. Authorizing through the returned function
+// follows the providers redirect to the callback and returns the query the
+// callback received.
+func newFakeFlow(t *testing.T) (*FakeProvider, *Provider, func(state, nonce, verifier string) url.Values) {
+	t.Helper()
+	var fake *FakeProvider
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(issuer.Close)
+
+	received := make(chan url.Values, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Query()
+	}))
+	t.Cleanup(callback.Close)
+
+	fake, err := NewFakeProvider(issuer.URL+"/oidc/fake", "chirpy")
+	if err != nil {
+		t.Fatalf("Couldnt create fake provider: %s", err)
+	}
+	provider := NewProvider(Config{
+		Name:        "fake",
+		Issuer:      fake.Issuer,
+		ClientID:    fake.ClientID,
+		RedirectURL: callback.URL + "/api/auth/fake/callback",
+	})
+
+	authorize := func(state, nonce, verifier string) url.Values {
+		t.Helper()
+		authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+		if err != nil {
+			t.Fatalf("Couldnt build authorization url: %s", err)
+		}
+		resp, err := http.Get(authURL)
+		if err != nil {
+			t.Fatalf("Couldnt authorize: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Authorization should end at the callback, got status %d", resp.StatusCode)
+		}
+		return <-received
+	}
+	return fake, provider, authorize
+}
+
+func TestFakeProviderFlow(t *testing.T) {
+	fake, provider, authorize := newFakeFlow(t)
+	verifier, _ := NewCodeVerifier()
+
+	query := authorize("state-1", "nonce-1", verifier)
+	if query.Get("state") != "state-1" {
+		t.Errorf("Callback state should be %s not %s", "state-1", query.Get("state"))
+	}
+	claims, err := provider.Exchange(context.Background(), query.Get("code"), verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	if claims.Subject != fake.User.Subject {
+		t.Errorf("Subject should be %s not %s", fake.User.Subject, claims.Subject)
+	}
+	if claims.Email != fake.User.Email || !claims.EmailVerified {
+		t.Errorf("Email should be verified %s not %s (verified %v)", fake.User.Email, claims.Email, claims.EmailVerified)
+	}
+}
+
+func TestFakeProviderRejectsBadExchange(t *testing.T) {
+	_, provider, authorize := newFakeFlow(t)
+
+	cases := []struct {
+		name     string
+		verifier string
+		nonce    string
+		reuse    bool
+	}{
+		{name: "wrong code verifier", verifier: "not-the-verifier", nonce: "nonce"},
+		{name: "wrong nonce", nonce: "other-nonce"},
+		{name: "reused code", nonce: "nonce", reuse: true},
+	}
+	for _, c := range cases {
+		verifier, _ := NewCodeVerifier()
+		code := authorize("state", "nonce", verifier).Get("code")
+		if c.reuse {
+			_, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+			if err != nil {
+				t.Errorf("%s: first exchange should succeed: %s", c.name, err)
+			}
+		}
+		if c.verifier != "" {
+			verifier = c.verifier
+		}
+		_, err := provider.Exchange(context.Background(), code, verifier, c.nonce)
+		if err == nil {
+			t.Errorf("%s: exchange should fail", c.name)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+func randomString(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func NewState() (string, error) {
+	return randomString(24)
+}
+
+func NewNonce() (string, error) {
+	return randomString(24)
+}
+
+// NewCodeVerifier returns a PKCE code verifier as described in RFC 7636.
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,152 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+var ErrNotHTML = errors.New("response is not an HTML page")
+
+// Fetcher loads the preview card for a link.
+type Fetcher interface {
+	Fetch(ctx context.Context, link string) (database.LinkPreview, error)
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// ExtractURLs returns up to max distinct http and https URLs in body, in
+// the order they appear. Trailing punctuation is not part of a URL.
+func ExtractURLs(body string, max int) []string {
+	urls := []string{}
+	seen := map[string]struct{}{}
+	for _, match := range urlPattern.FindAllString(body, -1) {
+		match = strings.TrimRight(match, ".,:;!?)]}'")
+		parsed, err := url.Parse(match)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		if _, ok := seen[match]; ok {
+			continue
+		}
+		seen[match] = struct{}{}
+		urls = append(urls, match)
+		if len(urls) == max {
+			break
+		}
+	}
+	return urls
+}
+
+// HTTPFetcher reads the OpenGraph tags, or failing that the title, of a
+// page. Client decides what may be connected to and how long a request may
+// take; use a netguard client in production. Only the first MaxBytes of a
+// page are read.
+type HTTPFetcher struct {
+	Client   *http.Client
+	MaxBytes int64
+}
+
+func NewHTTPFetcher(client *http.Client) *HTTPFetcher {
+	return &HTTPFetcher{
+		Client:   client,
+		MaxBytes: 512 * 1024,
+	}
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, link string) (database.LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return database.LinkPreview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "Chirpy-LinkPreview/1.0")
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return database.LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return database.LinkPreview{}, fmt.Errorf("fetch %s: status %d", link, resp.StatusCode)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return database.LinkPreview{}, ErrNotHTML
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBytes))
+	if err != nil {
+		return database.LinkPreview{}, err
+	}
+	card := parse(string(page), resp.Request.URL)
+	card.URL = link
+	return card, nil
+}
+
+var (
+	metaPattern      = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+)
+
+// parse reads the card from page, which was served from base. It does not
+// build a DOM: matching the few tags a card needs is enough, and a page
+// cut off at MaxBytes still yields what was read.
+func parse(page string, base *url.URL) database.LinkPreview {
+	meta := map[string]string{}
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		attributes := map[string]string{}
+		for _, match := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(match[1])] = match[2] + match[3]
+		}
+		key := attributes["property"]
+		if key == "" {
+			key = attributes["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = clean(attributes["content"], maxDescriptionLength)
+		}
+	}
+	card := database.LinkPreview{
+		Title:       meta["og:title"],
+		Description: meta["og:description"],
+		SiteName:    meta["og:site_name"],
+	}
+	if card.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			card.Title = clean(match[1], maxTitleLength)
+		}
+	}
+	if card.Description == "" {
+		card.Description = meta["description"]
+	}
+	card.Title = clean(card.Title, maxTitleLength)
+	if image, err := base.Parse(meta["og:image"]); err == nil && meta["og:image"] != "" {
+		if image.Scheme == "http" || image.Scheme == "https" {
+			card.ImageURL = image.String()
+		}
+	}
+	return card
+}
+
+func clean(text string, max int) string {
+	text = strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+	if len(text) > max {
+		text = strings.ToValidUTF8(text[:max], "")
+	}
+	return text
+}
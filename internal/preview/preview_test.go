@@ -0,0 +1,101 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/netguard"
+)
+
+// newPage serves body with contentType and counts the requests it gets.
+func newPage(t *testing.T, contentType, body string) (*httptest.Server, *int32) {
+	t.Helper()
+	hits := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, hits
+}
+
+func TestHTTPFetcherReadsCard(t *testing.T) {
+	server, _ := newPage(t, "text/html; charset=utf-8", `<html><head>
+		<title>Fallback</title>
+		<meta property="og:title" content="Chirpy &amp; friends">
+		<meta property="og:description" content="  A   short
+			description ">
+		<meta property="og:image" content="/logo.png">
+	</head></html>`)
+	fetcher := NewHTTPFetcher(netguard.NewClient(time.Second, true))
+
+	card, err := fetcher.Fetch(context.Background(), server.URL+"/post")
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	if card.URL != server.URL+"/post" {
+		t.Errorf("URL should be %s not %s", server.URL+"/post", card.URL)
+	}
+	if card.Title != "Chirpy & friends" {
+		t.Errorf("Title should be %s not %s", "Chirpy & friends", card.Title)
+	}
+	if card.Description != "A short description" {
+		t.Errorf("Description should be %s not %s", "A short description", card.Description)
+	}
+	if card.ImageURL != server.URL+"/logo.png" {
+		t.Errorf("Image should be %s not %s", server.URL+"/logo.png", card.ImageURL)
+	}
+}
+
+func TestHTTPFetcherStopsAtMaxBytes(t *testing.T) {
+	head := `<html><head><title>Read</title>`
+	page := head + strings.Repeat(" ", 4096) + `<meta property="og:title" content="Not read"></head></html>`
+	server, _ := newPage(t, "text/html", page)
+	fetcher := NewHTTPFetcher(netguard.NewClient(time.Second, true))
+	fetcher.MaxBytes = int64(len(head) + 1024)
+
+	card, err := fetcher.Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	if card.Title != "Read" {
+		t.Errorf("Title should be %s not %s", "Read", card.Title)
+	}
+}
+
+func TestHTTPFetcherRejectsNonHTML(t *testing.T) {
+	cases := []string{
+		"application/json",
+		"image/png",
+		"",
+	}
+	for _, contentType := range cases {
+		server, _ := newPage(t, contentType, `<title>Not a page</title>`)
+		fetcher := NewHTTPFetcher(netguard.NewClient(time.Second, true))
+
+		_, err := fetcher.Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrNotHTML) {
+			t.Errorf("Content type %q should fail with %v not %v", contentType, ErrNotHTML, err)
+		}
+	}
+}
+
+func TestHTTPFetcherRejectsPrivateTargets(t *testing.T) {
+	server, hits := newPage(t, "text/html", `<title>Internal</title>`)
+	fetcher := NewHTTPFetcher(netguard.NewClient(time.Second, false))
+
+	_, err := fetcher.Fetch(context.Background(), server.URL)
+	if !errors.Is(err, netguard.ErrForbiddenAddress) {
+		t.Errorf("Loopback target should fail with %v not %v", netguard.ErrForbiddenAddress, err)
+	}
+	if atomic.LoadInt32(hits) != 0 {
+		t.Errorf("Loopback target shouldnt be requested")
+	}
+}
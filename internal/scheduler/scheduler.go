@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// Handler runs one job. Returning an error retries the job later.
+type Handler func(ctx context.Context, job database.Job) error
+
+// Scheduler runs jobs persisted in the database once they are due. Jobs
+// are claimed with a lease before they run, so a job is only run by one
+// worker at a time, and a job that was running when the server stopped is
+// picked up again once its lease runs out. Handlers should therefore be
+// idempotent.
+type Scheduler struct {
+	DB           *database.DB
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	wake     chan struct{}
+}
+
+func New(db *database.DB) *Scheduler {
+	return &Scheduler{
+		DB:           db,
+		PollInterval: 5 * time.Second,
+		Lease:        5 * time.Minute,
+		MaxAttempts:  5,
+		BaseBackoff:  10 * time.Second,
+		MaxBackoff:   time.Hour,
+		handlers:     map[string]Handler{},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler for jobs of kind.
+func (s *Scheduler) Handle(kind string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = handler
+}
+
+// Every registers a periodic job that runs handler every interval. The job
+// is persisted, so a restart does not reset its schedule.
+func (s *Scheduler) Every(name string, interval time.Duration, handler Handler) error {
+	s.Handle(name, handler)
+	_, err := s.DB.EnsurePeriodicJob(name, interval)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", name, err)
+	}
+	s.Wake()
+	return nil
+}
+
+// Wake makes the worker look for due jobs right away.
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		s.runDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context) {
+	due, err := s.DB.ClaimDueJobs(time.Now().UTC(), s.Lease, 50)
+	if err != nil {
+		log.Printf("Couldnt claim due jobs: %s", err)
+		return
+	}
+	for _, job := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		s.run(ctx, job)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job database.Job) {
+	s.mu.RLock()
+	handler, ok := s.handlers[job.Kind]
+	s.mu.RUnlock()
+	if !ok {
+		s.fail(job, fmt.Errorf("no handler for job kind %q", job.Kind))
+		return
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, s.Lease)
+	defer cancel()
+	if err := handler(runCtx, job); err != nil {
+		s.fail(job, err)
+		return
+	}
+	next := time.Time{}
+	if job.Interval > 0 {
+		next = time.Now().UTC().Add(time.Duration(job.Interval) * time.Second)
+	}
+	if err := s.DB.CompleteJob(job.ID, next); err != nil {
+		log.Printf("Couldnt complete job %d: %s", job.ID, err)
+	}
+}
+
+// fail retries one-off jobs with exponential backoff until MaxAttempts.
+// Periodic jobs are never given up on; they just wait for their next run.
+func (s *Scheduler) fail(job database.Job, jobErr error) {
+	log.Printf("Job %d (%s) failed: %s", job.ID, job.Kind, jobErr)
+	now := time.Now().UTC()
+	retryAt := now.Add(s.backoff(job.Attempts))
+	dead := false
+	if job.Interval > 0 {
+		retryAt = now.Add(time.Duration(job.Interval) * time.Second)
+	} else if job.Attempts >= s.MaxAttempts {
+		dead = true
+	}
+	if err := s.DB.FailJob(job.ID, jobErr.Error(), retryAt, dead); err != nil {
+		log.Printf("Couldnt record failure of job %d: %s", job.ID, err)
+	}
+}
+
+func (s *Scheduler) backoff(attempts int) time.Duration {
+	backoff := float64(s.BaseBackoff) * math.Pow(2, float64(attempts-1))
+	if backoff > float64(s.MaxBackoff) {
+		return s.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+	EventUserUpgraded = "user.upgraded"
+)
+
+var Events = []string{EventChirpCreated, EventChirpDeleted, EventUserUpgraded}
+
+type envelope struct {
+	ID        int         `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher queues events in the database and delivers them from a
+// background worker, retrying failed deliveries with exponential backoff
+// until MaxAttempts is reached and the delivery is marked dead.
+type Dispatcher struct {
+	DB           *database.DB
+	Client       *http.Client
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+
+	wake chan struct{}
+}
+
+func NewDispatcher(db *database.DB, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		DB:           db,
+		Client:       client,
+		MaxAttempts:  8,
+		BaseBackoff:  10 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Publish queues event for the global endpoints and those owned by
+// subjectUserID. Delivery happens asynchronously.
+func (d *Dispatcher) Publish(event string, subjectUserID int, data interface{}) error {
+	now := time.Now().UTC()
+	queued, err := d.DB.EnqueueWebhookEvent(event, subjectUserID, func(deliveryID int) (string, error) {
+		body, err := json.Marshal(envelope{
+			ID:        deliveryID,
+			Event:     event,
+			CreatedAt: now,
+			Data:      data,
+		})
+		return string(body), err
+	})
+	if err != nil {
+		return err
+	}
+	if queued > 0 {
+		d.Wake()
+	}
+	return nil
+}
+
+// Wake makes the worker look for due deliveries right away.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	due, err := d.DB.GetDueDeliveries(time.Now().UTC(), 50)
+	if err != nil {
+		log.Printf("Couldnt load due webhook deliveries: %s", err)
+		return
+	}
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		d.attempt(ctx, delivery)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery database.OutgoingDelivery) {
+	now := time.Now().UTC()
+	attempt := database.DeliveryAttempt{At: now}
+
+	endpoint, err := d.DB.GetWebhookEndpoint(delivery.EndpointID)
+	if err != nil {
+		attempt.Error = "endpoint no longer exists"
+		d.record(delivery.ID, attempt, database.OutgoingDead, time.Time{})
+		return
+	}
+
+	attempt.StatusCode, err = d.send(ctx, endpoint, delivery)
+	if err == nil {
+		d.record(delivery.ID, attempt, database.OutgoingDelivered, time.Time{})
+		return
+	}
+	attempt.Error = err.Error()
+	attempts := len(delivery.Attempts) + 1
+	if attempts >= d.MaxAttempts {
+		d.record(delivery.ID, attempt, database.OutgoingDead, time.Time{})
+		return
+	}
+	d.record(delivery.ID, attempt, database.OutgoingPending, now.Add(d.backoff(attempts)))
+}
+
+func (d *Dispatcher) send(ctx context.Context, endpoint database.WebhookEndpoint, delivery database.OutgoingDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set("X-Chirpy-Event", delivery.Event)
+	req.Header.Set("X-Chirpy-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Chirpy-Signature", auth.SignWebhookPayload(body, endpoint.Secret, time.Now()))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := float64(d.BaseBackoff) * math.Pow(2, float64(attempts-1))
+	if backoff > float64(d.MaxBackoff) {
+		return d.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+func (d *Dispatcher) record(id int, attempt database.DeliveryAttempt, status database.OutgoingStatus, next time.Time) {
+	err := d.DB.RecordDeliveryAttempt(id, attempt, status, next)
+	if err != nil {
+		log.Printf("Couldnt record webhook delivery %d: %s", id, err)
+	}
+}
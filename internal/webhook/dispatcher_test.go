@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// testDB is shared by the tests, since NewDB can only be called once per
+// process. Each test registers endpoints for its own owner, so they only
+// see their own deliveries.
+var testDB *database.DB
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "chirpy-webhook")
+	if err != nil {
+		panic(err)
+	}
+	testDB, err = database.NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver serves a webhook receiver that answers with status and
+// records every request it gets.
+func newReceiver(t *testing.T, status int) (*httptest.Server, func() []receivedRequest) {
+	t.Helper()
+	mu := sync.Mutex{}
+	received := []receivedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, receivedRequest{header: r.Header.Clone(), body: body})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedRequest{}, received...)
+	}
+}
+
+// publishTo registers an endpoint for url owned by ownerID, publishes an
+// event for that owner and returns the queued delivery.
+func publishTo(t *testing.T, d *Dispatcher, ownerID int, url, secret string) database.OutgoingDelivery {
+	t.Helper()
+	endpoint, err := testDB.CreateWebhookEndpoint(database.WebhookEndpoint{
+		OwnerID: ownerID,
+		URL:     url,
+		Secret:  secret,
+		Events:  []string{EventChirpCreated},
+	})
+	if err != nil {
+		t.Fatalf("Couldnt create endpoint: %s", err)
+	}
+	err = d.Publish(EventChirpCreated, ownerID, map[string]int{"id": 1})
+	if err != nil {
+		t.Fatalf("Couldnt publish event: %s", err)
+	}
+	deliveries, err := testDB.GetOutgoingDeliveries([]int{endpoint.ID}, "")
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Publishing should queue one delivery, got %d (%v)", len(deliveries), err)
+	}
+	return deliveries[0]
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	server, received := newReceiver(t, http.StatusNoContent)
+	d := NewDispatcher(testDB, server.Client())
+	delivery := publishTo(t, d, 1, server.URL, "endpoint-secret")
+
+	d.deliverDue(context.Background())
+
+	requests := received()
+	if len(requests) != 1 {
+		t.Fatalf("Receiver should get one request not %d", len(requests))
+	}
+	request := requests[0]
+	if string(request.body) != delivery.Payload {
+		t.Errorf("Body should be %s not %s", delivery.Payload, request.body)
+	}
+	if request.header.Get("X-Chirpy-Event") != EventChirpCreated {
+		t.Errorf("Event header should be %s not %s", EventChirpCreated, request.header.Get("X-Chirpy-Event"))
+	}
+	signature := request.header.Get("X-Chirpy-Signature")
+	if err := auth.VerifyWebhookSignature(signature, request.body, "endpoint-secret", time.Minute, time.Now()); err != nil {
+		t.Errorf("Signature should verify: %s", err)
+	}
+	if err := auth.VerifyWebhookSignature(signature, request.body, "other-secret", time.Minute, time.Now()); err == nil {
+		t.Errorf("Signature shouldnt verify with another secret")
+	}
+
+	delivery, _ = testDB.GetOutgoingDelivery(delivery.ID)
+	if delivery.Status != database.OutgoingDelivered {
+		t.Errorf("Status should be %s not %s", database.OutgoingDelivered, delivery.Status)
+	}
+}
+
+func TestDispatcherRetriesUntilDead(t *testing.T) {
+	server, received := newReceiver(t, http.StatusInternalServerError)
+	d := NewDispatcher(testDB, server.Client())
+	d.MaxAttempts = 3
+	d.BaseBackoff = time.Minute
+	delivery := publishTo(t, d, 2, server.URL, "endpoint-secret")
+
+	cases := []struct {
+		status  database.OutgoingStatus
+		backoff time.Duration
+	}{
+		{status: database.OutgoingPending, backoff: time.Minute},
+		{status: database.OutgoingPending, backoff: 2 * time.Minute},
+		{status: database.OutgoingDead},
+	}
+	for i, cas := range cases {
+		before := time.Now()
+		d.attempt(context.Background(), delivery)
+		delivery, _ = testDB.GetOutgoingDelivery(delivery.ID)
+
+		if delivery.Status != cas.status {
+			t.Errorf("Attempt %d: status should be %s not %s", i+1, cas.status, delivery.Status)
+		}
+		if len(delivery.Attempts) != i+1 {
+			t.Fatalf("Attempt %d: should be logged, got %d attempts", i+1, len(delivery.Attempts))
+		}
+		if code := delivery.Attempts[i].StatusCode; code != http.StatusInternalServerError {
+			t.Errorf("Attempt %d: status code should be %d not %d", i+1, http.StatusInternalServerError, code)
+		}
+		if cas.backoff == 0 {
+			continue
+		}
+		wait := delivery.NextAttemptAt.Sub(before)
+		if wait < cas.backoff || wait > cas.backoff+time.Second {
+			t.Errorf("Attempt %d: next attempt should be in %s not %s", i+1, cas.backoff, wait)
+		}
+	}
+	if len(received()) != len(cases) {
+		t.Errorf("Receiver should get %d requests not %d", len(cases), len(received()))
+	}
+
+	// Dead deliveries are no longer picked up.
+	d.deliverDue(context.Background())
+	if len(received()) != len(cases) {
+		t.Errorf("Dead delivery shouldnt be sent again")
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := NewDispatcher(testDB, nil)
+	d.BaseBackoff = 10 * time.Second
+	d.MaxBackoff = time.Minute
+
+	cases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: 10 * time.Second},
+		{attempts: 2, expected: 20 * time.Second},
+		{attempts: 3, expected: 40 * time.Second},
+		{attempts: 4, expected: time.Minute},
+		{attempts: 20, expected: time.Minute},
+	}
+	for _, cas := range cases {
+		if actual := d.backoff(cas.attempts); actual != cas.expected {
+			t.Errorf("Backoff after %d attempts should be %s not %s", cas.attempts, cas.expected, actual)
+		}
+	}
+}
+
+func TestDeletedEndpointsArentReused(t *testing.T) {
+	server, _ := newReceiver(t, http.StatusInternalServerError)
+	d := NewDispatcher(testDB, server.Client())
+	d.MaxAttempts = 1
+	delivery := publishTo(t, d, 3, server.URL, "endpoint-secret")
+	d.attempt(context.Background(), delivery)
+
+	err := testDB.DeleteWebhookEndpoint(delivery.EndpointID)
+	if err != nil {
+		t.Fatalf("Couldnt delete endpoint: %s", err)
+	}
+	if _, err := testDB.GetOutgoingDelivery(delivery.ID); err == nil {
+		t.Errorf("Dead delivery of a deleted endpoint should be deleted")
+	}
+
+	next := publishTo(t, d, 4, server.URL, "other-secret")
+	if next.EndpointID == delivery.EndpointID {
+		t.Errorf("New endpoint shouldnt get the deleted endpoints id %d", delivery.EndpointID)
+	}
+	if next.ID == delivery.ID {
+		t.Errorf("New delivery shouldnt get the deleted deliverys id %d", delivery.ID)
+	}
+	if _, err := testDB.RequeueDelivery(next.ID); err != database.ErrDeliveryNotDead {
+		t.Errorf("Requeueing a pending delivery should fail with %v not %v", database.ErrDeliveryNotDead, err)
+	}
+}
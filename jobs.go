@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// registerJobs wires the background jobs into the scheduler.
+func (cfg *apiConfig) registerJobs() error {
+	cfg.Scheduler.Handle(database.JobPublishChirp, cfg.publishScheduledChirp)
+	cfg.Scheduler.Handle(database.JobFetchLinkPreviews, cfg.fetchLinkPreviews)
+	cfg.Scheduler.Handle(database.JobExportUserData, cfg.exportUserData)
+	err := cfg.Scheduler.Every(
+		"expire-subscriptions",
+		envSeconds("SUBSCRIPTION_EXPIRY_INTERVAL_SECONDS", time.Hour),
+		func(ctx context.Context, job database.Job) error {
+			return cfg.expireSubscriptions()
+		},
+	)
+	if err != nil {
+		return err
+	}
+	retention := envSeconds("TOMBSTONE_RETENTION_SECONDS", 30*24*time.Hour)
+	return cfg.Scheduler.Every(
+		"purge-tombstones",
+		envSeconds("TOMBSTONE_PURGE_INTERVAL_SECONDS", 24*time.Hour),
+		func(ctx context.Context, job database.Job) error {
+			purged, err := cfg.DB.PurgeTombstones(time.Now().UTC().Add(-retention))
+			if err != nil {
+				return err
+			}
+			if purged > 0 {
+				log.Printf("Purged %d tombstones", purged)
+			}
+			return cfg.purgeExpiredExports()
+		},
+	)
+}
+
+// publishScheduledChirp is safe to run twice: only the run that actually
+// publishes the chirp sends webhooks and notifications.
+func (cfg *apiConfig) publishScheduledChirp(ctx context.Context, job database.Job) error {
+	chirp, published, err := cfg.DB.PublishScheduledChirp(job.SubjectID)
+	if err != nil {
+		return err
+	}
+	if !published {
+		return nil
+	}
+	cfg.announceChirp(chirp)
+	return nil
+}
+
+// purgeExpiredExports removes data exports nobody downloaded in time.
+func (cfg *apiConfig) purgeExpiredExports() error {
+	files, err := cfg.DB.PurgeExpiredExports(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldnt remove export %s: %s", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/preview"
+)
+
+const maxLinkPreviews = 3
+
+// queueLinkPreviews has the previews of the links in chirp fetched in the
+// background, so publishing never waits on other servers.
+func (cfg *apiConfig) queueLinkPreviews(chirp database.Chirp) {
+	if cfg.LinkPreviews == nil || len(preview.ExtractURLs(chirp.Body, maxLinkPreviews)) == 0 {
+		return
+	}
+	_, err := cfg.DB.CreateJob(database.Job{
+		Kind:      database.JobFetchLinkPreviews,
+		SubjectID: chirp.ID,
+		OwnerID:   chirp.AuthorID,
+	})
+	if err != nil {
+		log.Printf("Couldnt queue link previews for chirp %d: %s", chirp.ID, err)
+		return
+	}
+	cfg.Scheduler.Wake()
+}
+
+// fetchLinkPreviews stores a card for every link that could be fetched.
+// Links that fail are left out rather than retried: the page is either not
+// there, not HTML or not allowed, and trying again wont change that.
+func (cfg *apiConfig) fetchLinkPreviews(ctx context.Context, job database.Job) error {
+	chirp, err := cfg.DB.GetChirp(job.SubjectID)
+	if errors.Is(err, database.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	previews := []database.LinkPreview{}
+	for _, link := range preview.ExtractURLs(chirp.Body, maxLinkPreviews) {
+		card, err := cfg.LinkPreviews.Fetch(ctx, link)
+		if err != nil {
+			log.Printf("Couldnt fetch link preview for %s: %s", link, err)
+			continue
+		}
+		previews = append(previews, card)
+	}
+	if len(previews) == 0 {
+		return nil
+	}
+	return cfg.DB.SetLinkPreviews(chirp.ID, previews)
+}
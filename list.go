@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+const (
+	maxListsPerUser   = 20
+	maxListMembers    = 500
+	maxListNameLength = 50
+)
+
+// decodeListName writes a 400 and returns false unless the body holds a
+// name of 1 to maxListNameLength characters.
+func decodeListName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return "", false
+	}
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > maxListNameLength {
+		respondWithError(w, http.StatusBadRequest, "List names must be 1 to 50 characters long")
+		return "", false
+	}
+	return name, true
+}
+
+func respondWithListError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, "Couldnt find list")
+	case errors.Is(err, database.ErrListLimit):
+		respondWithError(w, http.StatusForbidden, "You cant have more lists")
+	case errors.Is(err, database.ErrListFull):
+		respondWithError(w, http.StatusForbidden, "List cant have more members")
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Couldnt update list")
+	}
+}
+
+func (cfg *apiConfig) handleListCreate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	name, ok := decodeListName(w, r)
+	if !ok {
+		return
+	}
+	list, err := cfg.DB.CreateList(userID, name, maxListsPerUser)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusCreated, list)
+}
+
+func (cfg *apiConfig) handleListsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+	lists, err := cfg.DB.GetLists(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve lists")
+		return
+	}
+	respondWithJson(w, http.StatusOK, lists)
+}
+
+func (cfg *apiConfig) handleListRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+	listID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	list, err := cfg.DB.GetList(userID, listID)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, list)
+}
+
+func (cfg *apiConfig) handleListRename(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	listID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	name, ok := decodeListName(w, r)
+	if !ok {
+		return
+	}
+	list, err := cfg.DB.RenameList(userID, listID, name)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, list)
+}
+
+func (cfg *apiConfig) handleListDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	listID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	err = cfg.DB.DeleteList(userID, listID)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+// listMemberParams reads the list and member ids from the path.
+func listMemberParams(w http.ResponseWriter, r *http.Request) (listID, memberID int, ok bool) {
+	listID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return 0, 0, false
+	}
+	memberID, err = strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse user id")
+		return 0, 0, false
+	}
+	return listID, memberID, true
+}
+
+func (cfg *apiConfig) handleListMemberAdd(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	listID, memberID, ok := listMemberParams(w, r)
+	if !ok {
+		return
+	}
+	list, err := cfg.DB.AddListMember(userID, listID, memberID, maxListMembers)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find list or user")
+			return
+		}
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, list)
+}
+
+func (cfg *apiConfig) handleListMemberRemove(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+	listID, memberID, ok := listMemberParams(w, r)
+	if !ok {
+		return
+	}
+	list, err := cfg.DB.RemoveListMember(userID, listID, memberID)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, list)
+}
+
+// handleListChirpsRetrieve pages through the chirps of the lists members,
+// newest first.
+func (cfg *apiConfig) handleListChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps     []database.Chirp `json:"chirps"`
+		NextCursor int              `json:"next_cursor"`
+	}
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+	listID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	chirps, err := cfg.DB.GetListChirps(userID, listID, cursor, limit+1)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	nextCursor := 0
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		nextCursor = chirps[limit-1].ID
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Chirps:     chirps,
+		NextCursor: nextCursor,
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// loginLimiter tracks failed logins per account and per client IP and locks
+// either out with an exponentially growing backoff once a threshold is hit.
+type loginLimiter struct {
+	DB                 *database.DB
+	MaxAccountFailures int
+	MaxIPFailures      int
+	BaseLockout        time.Duration
+	MaxLockout         time.Duration
+	FailureWindow      time.Duration
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Attempt returns how long the caller has to wait if any of the keys is
+// locked out. Otherwise it counts the attempt as failed right away, until
+// Succeeded takes that back: checking and counting happen in one update,
+// so a burst of parallel guesses cant all get past the check before the
+// first failure is stored.
+func (l *loginLimiter) Attempt(accountKey, ipKey string) (time.Duration, error) {
+	wait := time.Duration(0)
+	err := l.DB.UpdateLoginAttempts([]string{accountKey, ipKey}, func(attempts []*database.LoginAttempt) error {
+		now := time.Now().UTC()
+		for _, attempt := range attempts {
+			if remaining := attempt.LockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+		if wait > 0 {
+			return nil
+		}
+		l.recordFailure(attempts[0], l.MaxAccountFailures, now)
+		l.recordFailure(attempts[1], l.MaxIPFailures, now)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return wait, nil
+}
+
+// Succeeded takes back the failure Attempt counted. The accounts failures
+// are cleared; the IP only gets this one back, since other accounts may
+// have been guessed from it.
+func (l *loginLimiter) Succeeded(accountKey, ipKey string) error {
+	return l.DB.UpdateLoginAttempts([]string{accountKey, ipKey}, func(attempts []*database.LoginAttempt) error {
+		*attempts[0] = database.LoginAttempt{Key: accountKey}
+		ip := attempts[1]
+		if ip.Failures > 0 {
+			ip.Failures--
+		}
+		if ip.Failures < l.MaxIPFailures {
+			ip.LockedUntil = time.Time{}
+		}
+		return nil
+	})
+}
+
+func (l *loginLimiter) recordFailure(attempt *database.LoginAttempt, threshold int, now time.Time) {
+	if now.Sub(attempt.LastFailure) > l.FailureWindow {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailure = now
+	if attempt.Failures >= threshold {
+		attempt.LockedUntil = now.Add(l.lockoutFor(attempt.Failures - threshold))
+	}
+}
+
+func (l *loginLimiter) lockoutFor(excess int) time.Duration {
+	lockout := float64(l.BaseLockout) * math.Pow(2, float64(excess))
+	if lockout > float64(l.MaxLockout) {
+		return l.MaxLockout
+	}
+	return time.Duration(lockout)
+}
+
+func (l *loginLimiter) Reset(key string) error {
+	return l.DB.DeleteLoginAttempt(key)
+}
+
+func respondWithRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts")
+}
+
+func (cfg *apiConfig) handleAdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	err = cfg.LoginLimiter.Reset(accountAttemptKey(user.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt unlock user")
+		return
+	}
+	cfg.audit(r, database.AuditUserUnlocked, userID, nil)
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
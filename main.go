@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -9,6 +11,8 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/entitlements"
+	"github.com/thorbenbender/chirpy/internal/events"
 )
 
 func main() {
@@ -22,35 +26,183 @@ func main() {
 	godotenv.Load()
 	jwtSecret := os.Getenv("JWT_SECRET")
 	polkaApiKey := os.Getenv("POLKA_API_KEY")
+	passwords, err := loadPasswords()
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mailer, err := loadMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	accountCfg := loadAccountConfig()
+	oidcProviders, fakeOIDC, err := loadOIDCProviders(accountCfg.BaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entitlementStore, err := entitlements.Load(
+		envString("ENTITLEMENTS_FILE", "./config/entitlements.json"),
+		string(database.PlanFree),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	eventBus := events.NewBus(envInt("EVENT_HISTORY_SIZE", 1000))
+	db.SetPublisher(eventBus)
 	apiCfg := apiConfig{
 		fileServerHits: 0,
 		DB:             db,
 		JWTSecret:      jwtSecret,
 		ApiKey:         polkaApiKey,
+		Passwords:      passwords,
+		PasswordPolicy: passwordPolicy,
+		LoginLimiter:   loadLoginLimiter(db),
+		Mailer:         mailer,
+		Account:        accountCfg,
+		OIDCProviders:  oidcProviders,
+		Polka:          loadPolkaConfig(),
+		Entitlements:   entitlementStore,
+		ChirpLimiter:   newUserRateLimiter(),
+		Webhooks:       loadWebhookDispatcher(db),
+		Events:         eventBus,
+		Scheduler:      loadScheduler(db),
+		LinkPreviews:   loadLinkPreviews(),
 	}
+	ran, err := runCommand(&apiCfg, flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ran {
+		return
+	}
+
+	err = apiCfg.registerJobs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go apiCfg.Scheduler.Run(context.Background())
+
+	reloadOnHangup(entitlementStore)
+	go apiCfg.Webhooks.Run(context.Background())
+
 	router := chi.NewRouter()
 	fsHandler := apiCfg.middlewareMetricsInc(
 		http.StripPrefix("/app", http.FileServer(http.Dir(filePathRoot))),
 	)
 	router.Handle("/app/*", fsHandler)
 	router.Handle("/app", fsHandler)
+	if fakeOIDC != nil {
+		router.Handle("/oidc/fake/*", fakeOIDC)
+	}
 
 	apiRouter := chi.NewRouter()
 	apiRouter.Get("/healthz", handleReadiness)
-	apiRouter.HandleFunc("/reset", apiCfg.handleReset)
+	apiRouter.With(apiCfg.middlewareRequire(policyAdmin)).HandleFunc("/reset", apiCfg.handleReset)
 	apiRouter.Post("/chirps", apiCfg.handlerChirpsCreate)
 	apiRouter.Get("/chirps", apiCfg.handlerChirpsRetrieve)
+	apiRouter.Get("/chirps/stream", apiCfg.handleChirpsStream)
+	apiRouter.Get("/chirps/scheduled", apiCfg.handleScheduledChirpsRetrieve)
+	apiRouter.Patch("/chirps/scheduled/{id}", apiCfg.handleScheduledChirpReschedule)
+	apiRouter.Delete("/chirps/scheduled/{id}", apiCfg.handleScheduledChirpCancel)
+	apiRouter.Get("/ws", apiCfg.handleWebsocket)
 	apiRouter.Get("/chirps/{id}", apiCfg.handlerChirpRetrieve)
+	apiRouter.Get("/chirps/{id}/poll", apiCfg.handlePollRetrieve)
+	apiRouter.Post("/chirps/{id}/poll/vote", apiCfg.handlePollVote)
+	apiRouter.Post("/chirps/{id}/bookmark", apiCfg.handleChirpBookmark)
+	apiRouter.Delete("/chirps/{id}/bookmark", apiCfg.handleChirpUnbookmark)
+	apiRouter.Get("/bookmarks", apiCfg.handleBookmarksRetrieve)
+	apiRouter.Post("/lists", apiCfg.handleListCreate)
+	apiRouter.Get("/lists", apiCfg.handleListsRetrieve)
+	apiRouter.Get("/lists/{id}", apiCfg.handleListRetrieve)
+	apiRouter.Patch("/lists/{id}", apiCfg.handleListRename)
+	apiRouter.Delete("/lists/{id}", apiCfg.handleListDelete)
+	apiRouter.Put("/lists/{id}/members/{userID}", apiCfg.handleListMemberAdd)
+	apiRouter.Delete("/lists/{id}/members/{userID}", apiCfg.handleListMemberRemove)
+	apiRouter.Get("/lists/{id}/chirps", apiCfg.handleListChirpsRetrieve)
+	apiRouter.Post("/drafts", apiCfg.handleDraftCreate)
+	apiRouter.Get("/drafts", apiCfg.handleDraftsRetrieve)
+	apiRouter.Get("/drafts/{id}", apiCfg.handleDraftRetrieve)
+	apiRouter.Put("/drafts/{id}", apiCfg.handleDraftUpdate)
+	apiRouter.Delete("/drafts/{id}", apiCfg.handleDraftDelete)
+	apiRouter.Post("/drafts/{id}/publish", apiCfg.handleDraftPublish)
 	apiRouter.Delete("/chirps/{id}", apiCfg.handlerChirpDelete)
 	apiRouter.Post("/users", apiCfg.handleUserCreate)
 	apiRouter.Post("/login", apiCfg.handleUserLogin)
 	apiRouter.Put("/users", apiCfg.handlerUserUpdate)
+	apiRouter.Patch("/users", apiCfg.handlerUserUpdate)
+	apiRouter.Post("/users/email/confirm", apiCfg.handleEmailChangeConfirm)
+	apiRouter.Delete("/users", apiCfg.handleUserDelete)
+	apiRouter.Post("/users/confirm-password", apiCfg.handlePasswordConfirm)
+	apiRouter.Get("/users/export", apiCfg.handleUserExport)
+	apiRouter.Get("/users/subscription", apiCfg.handleSubscriptionRetrieve)
+	apiRouter.Get("/users/entitlements", apiCfg.handleEntitlementsRetrieve)
+	apiRouter.Post("/users/{id}/follow", apiCfg.handleUserFollow)
+	apiRouter.Delete("/users/{id}/follow", apiCfg.handleUserUnfollow)
+	apiRouter.Get("/users/blocks", apiCfg.handleUserBlocksRetrieve)
+	apiRouter.Post("/users/{id}/block", apiCfg.handleUserBlock)
+	apiRouter.Delete("/users/{id}/block", apiCfg.handleUserUnblock)
+	apiRouter.Get("/users/mutes", apiCfg.handleUserMutesRetrieve)
+	apiRouter.Post("/users/{id}/mute", apiCfg.handleUserMute)
+	apiRouter.Delete("/users/{id}/mute", apiCfg.handleUserUnmute)
+	apiRouter.Get("/notifications", apiCfg.handleNotificationsRetrieve)
+	apiRouter.Post("/notifications/read-all", apiCfg.handleNotificationsReadAll)
+	apiRouter.Post("/notifications/{id}/read", apiCfg.handleNotificationRead)
+	apiRouter.Get("/notifications/preferences", apiCfg.handleNotificationPreferencesRetrieve)
+	apiRouter.Put("/notifications/preferences", apiCfg.handleNotificationPreferencesUpdate)
+	apiRouter.Post("/conversations", apiCfg.handleConversationCreate)
+	apiRouter.Get("/conversations", apiCfg.handleConversationsRetrieve)
+	apiRouter.Get("/conversations/{id}", apiCfg.handleConversationRetrieve)
+	apiRouter.Get("/conversations/{id}/messages", apiCfg.handleMessagesRetrieve)
+	apiRouter.Post("/conversations/{id}/messages", apiCfg.handleMessageCreate)
+	apiRouter.Post("/conversations/{id}/read", apiCfg.handleConversationRead)
+	apiRouter.Post("/users/verify", apiCfg.handleUserVerify)
+	apiRouter.Post("/users/verify/resend", apiCfg.handleUserVerifyResend)
+	apiRouter.Post("/password/forgot", apiCfg.handlePasswordForgot)
+	apiRouter.Post("/password/reset", apiCfg.handlePasswordReset)
+	apiRouter.Post("/2fa/setup", apiCfg.handle2FASetup)
+	apiRouter.Post("/2fa/enable", apiCfg.handle2FAEnable)
+	apiRouter.Post("/2fa/disable", apiCfg.handle2FADisable)
+	apiRouter.Post("/2fa/verify", apiCfg.handle2FAVerify)
+	apiRouter.Get("/auth/{provider}/login", apiCfg.handleOIDCLogin)
+	apiRouter.Get("/auth/{provider}/callback", apiCfg.handleOIDCCallback)
+	apiRouter.Post("/tokens", apiCfg.handlePersonalTokenCreate)
+	apiRouter.Get("/tokens", apiCfg.handlePersonalTokensRetrieve)
+	apiRouter.Delete("/tokens/{id}", apiCfg.handlePersonalTokenRevoke)
+	apiRouter.Post("/webhooks", apiCfg.handleWebhookCreate)
+	apiRouter.Get("/webhooks", apiCfg.handleWebhooksRetrieve)
+	apiRouter.Get("/webhooks/dead-letters", apiCfg.handleWebhookDeadLetters)
+	apiRouter.Delete("/webhooks/{id}", apiCfg.handleWebhookDelete)
+	apiRouter.Get("/webhooks/{id}/deliveries", apiCfg.handleWebhookDeliveries)
+	apiRouter.Post("/webhooks/deliveries/{id}/retry", apiCfg.handleWebhookDeliveryRetry)
 	apiRouter.Post("/refresh", apiCfg.HandleTokenRefresh)
 	apiRouter.Post("/revoke", apiCfg.HandleTokenRevoke)
 	apiRouter.Post("/polka/webhooks", apiCfg.HandlePolkaWebhook)
 
 	adminRouter := chi.NewRouter()
+	adminRouter.Use(apiCfg.middlewareRequire(policyAdmin))
 	adminRouter.Get("/metrics", apiCfg.handleMetrics)
+	adminRouter.Get("/users", apiCfg.handleAdminUsersRetrieve)
+	adminRouter.Get("/users/{id}", apiCfg.handleAdminUserRetrieve)
+	adminRouter.Delete("/users/{id}", apiCfg.handleAdminUserDelete)
+	adminRouter.Get("/users/{id}/sessions", apiCfg.handleAdminUserSessionsRetrieve)
+	adminRouter.Post("/users/{id}/unlock", apiCfg.handleAdminUnlockUser)
+	adminRouter.Put("/users/{id}/role", apiCfg.handleAdminUserRoleUpdate)
+	adminRouter.Post("/users/{id}/suspend", apiCfg.handleAdminUserSuspend)
+	adminRouter.Delete("/users/{id}/suspend", apiCfg.handleAdminUserUnsuspend)
+	adminRouter.Post("/users/{id}/password-reset", apiCfg.handleAdminUserPasswordReset)
+	adminRouter.Put("/users/{id}/chirpy-red", apiCfg.handleAdminChirpyRedGrant)
+	adminRouter.Delete("/users/{id}/chirpy-red", apiCfg.handleAdminChirpyRedRevoke)
+	adminRouter.Get("/audit-log", apiCfg.handleAdminAuditLog)
+	adminRouter.Get("/webhooks/deliveries", apiCfg.handleAdminWebhookDeliveries)
+	adminRouter.Post("/webhooks", apiCfg.handleAdminWebhookCreate)
+	adminRouter.Get("/webhooks", apiCfg.handleAdminWebhooksRetrieve)
+	adminRouter.Delete("/webhooks/{id}", apiCfg.handleAdminWebhookDelete)
+	adminRouter.Get("/webhooks/dead-letters", apiCfg.handleAdminWebhookDeadLetters)
+	adminRouter.Get("/webhooks/{id}/deliveries", apiCfg.handleAdminWebhookEndpointDeliveries)
+	adminRouter.Post("/webhooks/deliveries/{id}/retry", apiCfg.handleAdminWebhookDeliveryRetry)
 
 	router.Mount("/api", apiRouter)
 	router.Mount("/admin", adminRouter)
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// testDB is shared by the tests, since NewDB can only be called once per
+// process. Tests use their own users so they dont see each others data.
+var testDB *database.DB
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "chirpy")
+	if err != nil {
+		panic(err)
+	}
+	testDB, err = database.NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// Users are mentioned by their email address, as in "hi @jane@example.com".
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+
+// notify stores a notification. Failing to do so should not fail the
+// request that caused it, so errors are only logged.
+func (cfg *apiConfig) notify(notification database.Notification) {
+	if _, _, err := cfg.DB.CreateNotification(notification); err != nil {
+		log.Printf("Couldnt create %s notification for user %d: %s", notification.Type, notification.UserID, err)
+	}
+}
+
+// resolveMentions returns the ids of the users mentioned in body, other
+// than its author. Addresses that belong to nobody are not mentions.
+func (cfg *apiConfig) resolveMentions(body string, authorID int) []int {
+	ids := []int{}
+	seen := map[int]struct{}{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		user, err := cfg.DB.GetUserByEmail(match[1])
+		if err != nil || user.ID == authorID {
+			continue
+		}
+		if _, ok := seen[user.ID]; ok {
+			continue
+		}
+		seen[user.ID] = struct{}{}
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+// notifyChirp notifies the author of the chirp being replied to and every
+// user mentioned in it, each at most once.
+func (cfg *apiConfig) notifyChirp(chirp database.Chirp) {
+	notified := map[int]struct{}{}
+	if chirp.ReplyToID != 0 {
+		parent, err := cfg.DB.GetChirp(chirp.ReplyToID)
+		if err == nil {
+			notified[parent.AuthorID] = struct{}{}
+			cfg.notify(database.Notification{
+				UserID:  parent.AuthorID,
+				Type:    database.NotificationReply,
+				ActorID: chirp.AuthorID,
+				ChirpID: chirp.ID,
+			})
+		}
+	}
+	for _, id := range chirp.MentionIDs {
+		if _, ok := notified[id]; ok {
+			continue
+		}
+		notified[id] = struct{}{}
+		cfg.notify(database.Notification{
+			UserID:  id,
+			Type:    database.NotificationMention,
+			ActorID: chirp.AuthorID,
+			ChirpID: chirp.ID,
+		})
+	}
+}
+
+// handleNotificationsRetrieve pages through the users notifications newest
+// first. next_cursor is passed back as cursor to get the next page and is
+// zero on the last one.
+func (cfg *apiConfig) handleNotificationsRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Notifications []database.Notification `json:"notifications"`
+		UnreadCount   int                     `json:"unread_count"`
+		NextCursor    int                     `json:"next_cursor"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	// Ask for one extra to find out whether there is another page.
+	notifications, unread, err := cfg.DB.GetNotifications(userID, cursor, limit+1, unreadOnly)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve notifications")
+		return
+	}
+	nextCursor := 0
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		nextCursor = notifications[limit-1].ID
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Notifications: notifications,
+		UnreadCount:   unread,
+		NextCursor:    nextCursor,
+	})
+}
+
+func (cfg *apiConfig) handleNotificationRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	notificationID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	notification, err := cfg.DB.MarkNotificationRead(userID, notificationID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find notification")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt mark notification read")
+		return
+	}
+	respondWithJson(w, http.StatusOK, notification)
+}
+
+func (cfg *apiConfig) handleNotificationsReadAll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Marked int `json:"marked"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	marked, err := cfg.DB.MarkAllNotificationsRead(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt mark notifications read")
+		return
+	}
+	respondWithJson(w, http.StatusOK, response{Marked: marked})
+}
+
+// notificationPreferences lists every type, filling in the defaults.
+func notificationPreferences(user database.User) map[database.NotificationType]bool {
+	preferences := map[database.NotificationType]bool{}
+	for _, notificationType := range database.NotificationTypes {
+		preferences[notificationType] = user.WantsNotification(notificationType)
+	}
+	return preferences
+}
+
+func (cfg *apiConfig) handleNotificationPreferencesRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	respondWithJson(w, http.StatusOK, notificationPreferences(user))
+}
+
+// handleNotificationPreferencesUpdate takes an object of type to enabled.
+// Types that are left out keep their current setting.
+func (cfg *apiConfig) handleNotificationPreferencesUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	params := map[string]bool{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	preferences := map[database.NotificationType]bool{}
+	for name, enabled := range params {
+		notificationType, ok := database.ParseNotificationType(name)
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Unknown notification type "+name)
+			return
+		}
+		preferences[notificationType] = enabled
+	}
+	user, err := cfg.DB.SetNotificationPreferences(userID, preferences)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt update notification preferences")
+		return
+	}
+	respondWithJson(w, http.StatusOK, notificationPreferences(user))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/oidc"
+)
+
+const oauthStateTTL = 10 * time.Minute
+
+func (cfg *apiConfig) oidcProvider(w http.ResponseWriter, r *http.Request) (*oidc.Provider, bool) {
+	provider, ok := cfg.OIDCProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown login provider")
+		return nil, false
+	}
+	return provider, true
+}
+
+func (cfg *apiConfig) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+	state, err := oidc.NewState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt start login")
+		return
+	}
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt start login")
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt start login")
+		return
+	}
+	redirectURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Couldnt reach login provider %s: %s", provider.Name, err)
+		respondWithError(w, http.StatusBadGateway, "Couldnt reach login provider")
+		return
+	}
+	err = cfg.DB.SaveOAuthState(database.OAuthState{
+		State:        state,
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oauthStateTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt start login")
+		return
+	}
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		respondWithError(w, http.StatusUnauthorized, "Login was denied by the provider")
+		return
+	}
+	state, err := cfg.DB.TakeOAuthState(query.Get("state"))
+	if err != nil || state.Provider != provider.Name {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired login state")
+		return
+	}
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Printf("Couldnt finish login with %s: %s", provider.Name, err)
+		respondWithError(w, http.StatusUnauthorized, "Couldnt verify login with provider")
+		return
+	}
+
+	user, err := cfg.userForIdentity(provider.Name, claims)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "An account with this email already exists, log in with your password first")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt link account")
+		return
+	}
+	if user.TOTPEnabled {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+	cfg.respondWithSession(w, r, user)
+}
+
+// userForIdentity returns the user linked to the provider identity. Unknown
+// identities are linked to an existing verified user with the same verified
+// email, or get a new user.
+func (cfg *apiConfig) userForIdentity(provider string, claims oidc.Claims) (database.User, error) {
+	identity, err := cfg.DB.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		return cfg.DB.GetUser(identity.UserID)
+	}
+	if !errors.Is(err, database.ErrNotExist) {
+		return database.User{}, err
+	}
+	if claims.Email == "" {
+		return database.User{}, errors.New("provider returned no email")
+	}
+
+	user, err := cfg.DB.GetUserByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// Linking to an unverified account would let whoever signed up with
+		// the address first take over the providers account.
+		if !claims.EmailVerified || !user.IsVerified {
+			return database.User{}, database.ErrAlreadyExists
+		}
+	case errors.Is(err, database.ErrNotExist):
+		user, err = cfg.DB.CreateUser(claims.Email, "")
+		if err != nil {
+			return database.User{}, err
+		}
+		if claims.EmailVerified {
+			user, err = cfg.DB.VerifyUser(user.ID)
+			if err != nil {
+				return database.User{}, err
+			}
+		}
+	default:
+		return database.User{}, err
+	}
+
+	_, err = cfg.DB.CreateIdentity(provider, claims.Subject, user.ID, claims.Email)
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/oidc"
+)
+
+// newOIDCRouter serves the login and callback routes of a config whose
+// only provider is a FakeProvider on its own server.
+func newOIDCRouter(t *testing.T) http.Handler {
+	t.Helper()
+	var fake *oidc.FakeProvider
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(issuer.Close)
+	fake, err := oidc.NewFakeProvider(issuer.URL+"/oidc/fake", "chirpy")
+	if err != nil {
+		t.Fatalf("Couldnt create fake provider: %s", err)
+	}
+
+	cfg := &apiConfig{
+		DB:        testDB,
+		JWTSecret: "secret",
+		OIDCProviders: map[string]*oidc.Provider{
+			"fake": oidc.NewProvider(oidc.Config{
+				Name:        "fake",
+				Issuer:      fake.Issuer,
+				ClientID:    fake.ClientID,
+				RedirectURL: "http://localhost:8080/api/auth/fake/callback",
+			}),
+		},
+	}
+	router := chi.NewRouter()
+	router.Get("/api/auth/{provider}/login", cfg.handleOIDCLogin)
+	router.Get("/api/auth/{provider}/callback", cfg.handleOIDCCallback)
+	return router
+}
+
+// oidcLogin logs in through the fake provider as email and returns the
+// callbacks response.
+func oidcLogin(t *testing.T, router http.Handler, email string) *httptest.ResponseRecorder {
+	t.Helper()
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/api/auth/fake/login", nil))
+	if login.Code != http.StatusFound {
+		t.Fatalf("Login should redirect to the provider, got %d: %s", login.Code, login.Body)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(login.Header().Get("Location") + "&login_hint=" + url.QueryEscape(email))
+	if err != nil {
+		t.Fatalf("Couldnt authorize: %s", err)
+	}
+	resp.Body.Close()
+	callbackURL, err := resp.Location()
+	if err != nil {
+		t.Fatalf("Provider should redirect to the callback: %s", err)
+	}
+
+	callback := httptest.NewRecorder()
+	router.ServeHTTP(callback, httptest.NewRequest(http.MethodGet, callbackURL.RequestURI(), nil))
+	return callback
+}
+
+func TestOIDCCallbackLinksIdentities(t *testing.T) {
+	router := newOIDCRouter(t)
+
+	verified, err := testDB.CreateUser("oidc-verified@example.com", "hash")
+	if err != nil {
+		t.Fatalf("Couldnt create user: %s", err)
+	}
+	verified, err = testDB.VerifyUser(verified.ID)
+	if err != nil {
+		t.Fatalf("Couldnt verify user: %s", err)
+	}
+	_, err = testDB.CreateUser("oidc-unverified@example.com", "hash")
+	if err != nil {
+		t.Fatalf("Couldnt create user: %s", err)
+	}
+
+	cases := []struct {
+		name   string
+		email  string
+		status int
+		userID int
+	}{
+		{name: "new email gets a new user", email: "oidc-new@example.com", status: http.StatusOK},
+		{name: "verified account is linked", email: "oidc-verified@example.com", status: http.StatusOK, userID: verified.ID},
+		{name: "linked identity logs in again", email: "oidc-verified@example.com", status: http.StatusOK, userID: verified.ID},
+		{name: "unverified account isnt linked", email: "oidc-unverified@example.com", status: http.StatusConflict},
+	}
+	for _, c := range cases {
+		rec := oidcLogin(t, router, c.email)
+		if rec.Code != c.status {
+			t.Errorf("%s: status should be %d not %d: %s", c.name, c.status, rec.Code, rec.Body)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		session := authResponse{}
+		if err := json.NewDecoder(rec.Body).Decode(&session); err != nil {
+			t.Errorf("%s: Error: %s", c.name, err.Error())
+			continue
+		}
+		if session.Email != c.email || !session.IsVerified {
+			t.Errorf("%s: should log in verified as %s not %s (verified %v)", c.name, c.email, session.Email, session.IsVerified)
+		}
+		if c.userID != 0 && session.ID != c.userID {
+			t.Errorf("%s: should log in as user %d not %d", c.name, c.userID, session.ID)
+		}
+		if session.Token == "" || session.RefreshToken == "" {
+			t.Errorf("%s: should get a session", c.name)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/webhook"
+)
+
+type WebhookEndpoint struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Global    bool      `json:"global"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookEndpoint(endpoint database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:        endpoint.ID,
+		URL:       endpoint.URL,
+		Events:    endpoint.Events,
+		Global:    endpoint.Global,
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
+
+// publishEvent hands an event to the outgoing webhook dispatcher. Failing
+// to queue it must not fail the request that caused it.
+func (cfg *apiConfig) publishEvent(event string, subjectUserID int, data interface{}) {
+	if err := cfg.Webhooks.Publish(event, subjectUserID, data); err != nil {
+		log.Printf("Couldnt queue %s webhook: %s", event, err)
+	}
+}
+
+func validWebhookEvents(events []string) bool {
+	if len(events) == 0 {
+		return false
+	}
+	for _, event := range events {
+		known := event == "*"
+		for _, candidate := range webhook.Events {
+			if event == candidate {
+				known = true
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+// createWebhookEndpoint registers the endpoint in the request body and
+// writes the response. It returns false if that failed.
+func (cfg *apiConfig) createWebhookEndpoint(w http.ResponseWriter, r *http.Request, ownerID int, global bool) (database.WebhookEndpoint, bool) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	type response struct {
+		WebhookEndpoint
+		Secret string `json:"secret"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return database.WebhookEndpoint{}, false
+	}
+	target, err := url.Parse(params.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "URL must be an absolute http(s) URL")
+		return database.WebhookEndpoint{}, false
+	}
+	if !validWebhookEvents(params.Events) {
+		respondWithError(w, http.StatusBadRequest, "Events must be a non-empty list of known events or *")
+		return database.WebhookEndpoint{}, false
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt generate secret")
+		return database.WebhookEndpoint{}, false
+	}
+	endpoint, err := cfg.DB.CreateWebhookEndpoint(database.WebhookEndpoint{
+		OwnerID:   ownerID,
+		Global:    global,
+		URL:       target.String(),
+		Secret:    "whsec_" + hex.EncodeToString(secret),
+		Events:    params.Events,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt create webhook")
+		return database.WebhookEndpoint{}, false
+	}
+	respondWithJson(w, http.StatusCreated, response{
+		WebhookEndpoint: newWebhookEndpoint(endpoint),
+		Secret:          endpoint.Secret,
+	})
+	return endpoint, true
+}
+
+func (cfg *apiConfig) listWebhookEndpoints(w http.ResponseWriter, ownerID int, global bool) {
+	dbEndpoints, err := cfg.DB.GetWebhookEndpoints(ownerID, global)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve webhooks")
+		return
+	}
+	endpoints := make([]WebhookEndpoint, 0, len(dbEndpoints))
+	for _, endpoint := range dbEndpoints {
+		endpoints = append(endpoints, newWebhookEndpoint(endpoint))
+	}
+	respondWithJson(w, http.StatusOK, endpoints)
+}
+
+// ownsWebhookEndpoint reports whether endpoint is one of the global
+// endpoints, or one ownerID owns, as in GetWebhookEndpoints.
+func ownsWebhookEndpoint(endpoint database.WebhookEndpoint, ownerID int, global bool) bool {
+	if global {
+		return endpoint.Global
+	}
+	return !endpoint.Global && endpoint.OwnerID == ownerID
+}
+
+// ownedWebhookEndpoint loads the endpoint in the id URL param, answering
+// 404 for endpoints the user doesnt own, or for admins for endpoints that
+// arent global.
+func (cfg *apiConfig) ownedWebhookEndpoint(w http.ResponseWriter, r *http.Request, ownerID int, global bool) (database.WebhookEndpoint, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return database.WebhookEndpoint{}, false
+	}
+	endpoint, err := cfg.DB.GetWebhookEndpoint(id)
+	if err != nil || !ownsWebhookEndpoint(endpoint, ownerID, global) {
+		respondWithError(w, http.StatusNotFound, "Couldnt find webhook")
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+func (cfg *apiConfig) listWebhookDeliveries(w http.ResponseWriter, r *http.Request, ownerID int, global bool) {
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r, ownerID, global)
+	if !ok {
+		return
+	}
+	deliveries, err := cfg.DB.GetOutgoingDeliveries([]int{endpoint.ID}, database.OutgoingStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve deliveries")
+		return
+	}
+	respondWithJson(w, http.StatusOK, deliveries)
+}
+
+func (cfg *apiConfig) listWebhookDeadLetters(w http.ResponseWriter, ownerID int, global bool) {
+	endpoints, err := cfg.DB.GetWebhookEndpoints(ownerID, global)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve webhooks")
+		return
+	}
+	ids := make([]int, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		ids = append(ids, endpoint.ID)
+	}
+	deliveries, err := cfg.DB.GetOutgoingDeliveries(ids, database.OutgoingDead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve deliveries")
+		return
+	}
+	respondWithJson(w, http.StatusOK, deliveries)
+}
+
+// retryWebhookDelivery requeues the dead delivery in the id URL param and
+// writes the response. It returns false if that failed.
+func (cfg *apiConfig) retryWebhookDelivery(w http.ResponseWriter, r *http.Request, ownerID int, global bool) (database.OutgoingDelivery, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return database.OutgoingDelivery{}, false
+	}
+	delivery, err := cfg.DB.GetOutgoingDelivery(id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find delivery")
+		return database.OutgoingDelivery{}, false
+	}
+	endpoint, err := cfg.DB.GetWebhookEndpoint(delivery.EndpointID)
+	if err != nil || !ownsWebhookEndpoint(endpoint, ownerID, global) {
+		respondWithError(w, http.StatusNotFound, "Couldnt find delivery")
+		return database.OutgoingDelivery{}, false
+	}
+	delivery, err = cfg.DB.RequeueDelivery(delivery.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find delivery")
+			return database.OutgoingDelivery{}, false
+		}
+		if errors.Is(err, database.ErrDeliveryNotDead) {
+			respondWithError(w, http.StatusConflict, "Only dead deliveries can be retried")
+			return database.OutgoingDelivery{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retry delivery")
+		return database.OutgoingDelivery{}, false
+	}
+	cfg.Webhooks.Wake()
+	respondWithJson(w, http.StatusOK, delivery)
+	return delivery, true
+}
+
+func (cfg *apiConfig) handleWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	cfg.createWebhookEndpoint(w, r, userID, false)
+}
+
+func (cfg *apiConfig) handleWebhooksRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	cfg.listWebhookEndpoints(w, userID, false)
+}
+
+func (cfg *apiConfig) handleWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	endpoint, ok := cfg.ownedWebhookEndpoint(w, r, userID, false)
+	if !ok {
+		return
+	}
+	err := cfg.DB.DeleteWebhookEndpoint(endpoint.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt delete webhook")
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+func (cfg *apiConfig) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	cfg.listWebhookDeliveries(w, r, userID, false)
+}
+
+func (cfg *apiConfig) handleWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	cfg.listWebhookDeadLetters(w, userID, false)
+}
+
+func (cfg *apiConfig) handleWebhookDeliveryRetry(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	cfg.retryWebhookDelivery(w, r, userID, false)
+}
+
+func (cfg *apiConfig) handleAdminWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	admin, _ := requestUser(r)
+	endpoint, ok := cfg.createWebhookEndpoint(w, r, admin.ID, true)
+	if !ok {
+		return
+	}
+	cfg.audit(r, database.AuditWebhookCreated, 0, webhookAuditDetails(endpoint))
+}
+
+func webhookAuditDetails(endpoint database.WebhookEndpoint) map[string]string {
+	return map[string]string{
+		"webhook_id": strconv.Itoa(endpoint.ID),
+		"url":        endpoint.URL,
+	}
+}
+
+func (cfg *apiConfig) handleAdminWebhooksRetrieve(w http.ResponseWriter, r *http.Request) {
+	cfg.listWebhookEndpoints(w, 0, true)
+}
+
+func (cfg *apiConfig) handleAdminWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	endpoint, err := cfg.DB.GetWebhookEndpoint(id)
+	if err == nil {
+		err = cfg.DB.DeleteWebhookEndpoint(id)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find webhook")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt delete webhook")
+		return
+	}
+	targetUserID := 0
+	if !endpoint.Global {
+		targetUserID = endpoint.OwnerID
+	}
+	cfg.audit(r, database.AuditWebhookDeleted, targetUserID, webhookAuditDetails(endpoint))
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+func (cfg *apiConfig) handleAdminWebhookEndpointDeliveries(w http.ResponseWriter, r *http.Request) {
+	cfg.listWebhookDeliveries(w, r, 0, true)
+}
+
+func (cfg *apiConfig) handleAdminWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	cfg.listWebhookDeadLetters(w, 0, true)
+}
+
+func (cfg *apiConfig) handleAdminWebhookDeliveryRetry(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := cfg.retryWebhookDelivery(w, r, 0, true)
+	if !ok {
+		return
+	}
+	cfg.audit(r, database.AuditWebhookRetried, 0, map[string]string{
+		"webhook_id":  strconv.Itoa(delivery.EndpointID),
+		"delivery_id": strconv.Itoa(delivery.ID),
+	})
+}
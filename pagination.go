@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// parsePage reads the cursor and limit query parameters of an id-cursor
+// paginated listing. Cursor zero means the first page. It writes a 400 and
+// returns false if either is malformed.
+func parsePage(w http.ResponseWriter, r *http.Request) (cursor, limit int, ok bool) {
+	if cursorParam := r.URL.Query().Get("cursor"); cursorParam != "" {
+		parsed, err := strconv.Atoi(cursorParam)
+		if err != nil || parsed <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Couldnt parse cursor")
+			return 0, 0, false
+		}
+		cursor = parsed
+	}
+	limit = defaultPageSize
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Couldnt parse limit")
+			return 0, 0, false
+		}
+		limit = min(parsed, maxPageSize)
+	}
+	return cursor, limit, true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+const maxPersonalTokenDays = 365
+
+type PersonalToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func newPersonalToken(token database.PersonalToken) PersonalToken {
+	response := PersonalToken{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	}
+	if !token.RevokedAt.IsZero() {
+		response.RevokedAt = &token.RevokedAt
+	}
+	return response
+}
+
+func (cfg *apiConfig) handlePersonalTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	type response struct {
+		PersonalToken
+		Token string `json:"token"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		respondWithError(w, http.StatusBadRequest, "Token needs a name")
+		return
+	}
+	scopes, err := auth.ParseScopes(params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Token needs at least one scope")
+		return
+	}
+	if params.ExpiresInDays <= 0 || params.ExpiresInDays > maxPersonalTokenDays {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days must be between 1 and 365")
+		return
+	}
+
+	id, secret, token, err := auth.GeneratePersonalToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt generate token")
+		return
+	}
+	scopeNames := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scopeNames = append(scopeNames, string(scope))
+	}
+	now := time.Now().UTC()
+	stored, err := cfg.DB.CreatePersonalToken(database.PersonalToken{
+		ID:         id,
+		UserID:     userID,
+		Name:       name,
+		Scopes:     scopeNames,
+		SecretHash: auth.HashTokenSecret(secret),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Duration(params.ExpiresInDays) * 24 * time.Hour),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt create token")
+		return
+	}
+	respondWithJson(w, http.StatusCreated, response{
+		PersonalToken: newPersonalToken(stored),
+		Token:         token,
+	})
+}
+
+func (cfg *apiConfig) handlePersonalTokensRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	dbTokens, err := cfg.DB.GetUserPersonalTokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve tokens")
+		return
+	}
+	sort.Slice(dbTokens, func(i, j int) bool {
+		return dbTokens[i].CreatedAt.Before(dbTokens[j].CreatedAt)
+	})
+	tokens := make([]PersonalToken, 0, len(dbTokens))
+	for _, token := range dbTokens {
+		tokens = append(tokens, newPersonalToken(token))
+	}
+	respondWithJson(w, http.StatusOK, tokens)
+}
+
+func (cfg *apiConfig) handlePersonalTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.DB.RevokePersonalToken(userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find token")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt revoke token")
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
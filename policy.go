@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+// accessPolicy declares who may use a route: users with one of Roles, or
+// the owner of the resource when AllowOwner is set.
+type accessPolicy struct {
+	Roles      []database.Role
+	AllowOwner bool
+}
+
+var (
+	policyAdmin = accessPolicy{
+		Roles: []database.Role{database.RoleAdmin},
+	}
+	policyChirpDelete = accessPolicy{
+		Roles:      []database.Role{database.RoleModerator, database.RoleAdmin},
+		AllowOwner: true,
+	}
+)
+
+func (p accessPolicy) allows(user database.User, ownerID int) bool {
+	if p.AllowOwner && user.ID == ownerID {
+		return true
+	}
+	role := user.UserRole()
+	for _, allowed := range p.Roles {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// middlewareRequire only lets requests through whose access JWT belongs to
+// a user the role based policy allows. The user is stored in the request
+// context for the handler.
+func (cfg *apiConfig) middlewareRequire(policy accessPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := cfg.authenticate(w, r)
+			if !ok {
+				return
+			}
+			user, err := cfg.DB.GetUser(userID)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, "Couldnt find user")
+				return
+			}
+			if !policy.allows(user, 0) {
+				respondWithError(w, http.StatusForbidden, "You are not allowed to do this")
+				return
+			}
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func requestUser(r *http.Request) (database.User, bool) {
+	user, ok := r.Context().Value(userContextKey).(database.User)
+	return user, ok
+}
@@ -1,51 +1,244 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/thorbenbender/chirpy/internal/auth"
 	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/webhook"
 )
 
+const (
+	polkaSource          = "polka"
+	maxWebhookBodyBytes  = 1 << 20
+	maxLoggedPayloadSize = 4096
+)
+
+const (
+	polkaEventUpgraded      = "user.upgraded"
+	polkaEventDowngraded    = "user.downgraded"
+	polkaEventCanceled      = "subscription.canceled"
+	polkaEventRenewed       = "subscription.renewed"
+	polkaEventPaymentFailed = "subscription.payment_failed"
+)
+
+type polkaConfig struct {
+	SigningSecret      string
+	SignatureTolerance time.Duration
+	BillingPeriod      time.Duration
+	GracePeriod        time.Duration
+	// DeliveryLogSize is how many incoming deliveries are kept.
+	DeliveryLogSize int
+}
+
 func (cfg *apiConfig) HandlePolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery := database.WebhookDelivery{
+		Source:     polkaSource,
+		ReceivedAt: time.Now().UTC(),
+	}
+	code, msg := cfg.processPolkaWebhook(r, &delivery)
+	delivery.HTTPStatus = code
+	if code >= http.StatusBadRequest {
+		delivery.Error = msg
+	}
+	if delivery.Outcome == database.WebhookRejected {
+		// Whoever sent it may not be Polka, so its payload isnt worth
+		// keeping.
+		delivery.Payload = ""
+	}
+	if _, err := cfg.DB.CreateWebhookDelivery(delivery, cfg.Polka.DeliveryLogSize); err != nil {
+		log.Printf("Couldnt log webhook delivery: %s", err)
+	}
+
+	if code >= http.StatusBadRequest {
+		respondWithError(w, code, msg)
+		return
+	}
+	respondWithJson(w, code, struct{}{})
+}
+
+// processPolkaWebhook authenticates and handles a delivery, filling in
+// delivery as it goes, and returns the status code and error message to
+// respond with.
+func (cfg *apiConfig) processPolkaWebhook(r *http.Request, delivery *database.WebhookDelivery) (int, string) {
 	type parameters struct {
+		ID    string `json:"id"`
 		Event string `json:"event"`
 		Data  struct {
-			UserID int `json:"user_id"`
+			UserID           int       `json:"user_id"`
+			CurrentPeriodEnd time.Time `json:"current_period_end"`
 		} `json:"data"`
 	}
-	apiKey, err := auth.GetBearerToken(r.Header, "ApiKey")
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "No ApiKey found")
-		return
+		delivery.Outcome = database.WebhookRejected
+		return http.StatusBadRequest, "Couldnt read body"
 	}
-	if apiKey != cfg.ApiKey {
-		respondWithError(w, http.StatusUnauthorized, "Invalid Request")
-		return
+	delivery.Payload = string(body)
+	if len(delivery.Payload) > maxLoggedPayloadSize {
+		delivery.Payload = delivery.Payload[:maxLoggedPayloadSize]
 	}
-	decoder := json.NewDecoder(r.Body)
+
+	if code, msg := cfg.authenticatePolka(r, body); code != 0 {
+		delivery.Outcome = database.WebhookRejected
+		return code, msg
+	}
+
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err = json.Unmarshal(body, &params)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
-		return
+		delivery.Outcome = database.WebhookRejected
+		return http.StatusBadRequest, "Couldnt decode parameters"
+	}
+	delivery.Event = params.Event
+	delivery.EventID = params.ID
+	if delivery.EventID == "" {
+		delivery.EventID = r.Header.Get("X-Polka-Event-Id")
 	}
 
-	if params.Event != "user.upgraded" {
-		respondWithJson(w, http.StatusOK, struct{}{})
-		return
+	switch params.Event {
+	case polkaEventUpgraded,
+		polkaEventDowngraded,
+		polkaEventCanceled,
+		polkaEventRenewed,
+		polkaEventPaymentFailed:
+	default:
+		delivery.Outcome = database.WebhookIgnored
+		return http.StatusOK, ""
+	}
+
+	if delivery.EventID != "" {
+		err = cfg.DB.ClaimWebhookEvent(polkaSource, delivery.EventID)
+		if errors.Is(err, database.ErrAlreadyExists) {
+			delivery.Outcome = database.WebhookDuplicate
+			return http.StatusOK, ""
+		}
+		if err != nil {
+			delivery.Outcome = database.WebhookFailed
+			return http.StatusInternalServerError, "Couldnt record event"
+		}
 	}
 
-	err = cfg.DB.UpgradeUser(params.Data.UserID)
+	err = cfg.applyPolkaEvent(params.Event, params.Data.UserID, params.Data.CurrentPeriodEnd)
 	if err != nil {
+		if delivery.EventID != "" {
+			if err := cfg.DB.ReleaseWebhookEvent(polkaSource, delivery.EventID); err != nil {
+				log.Printf("Couldnt release webhook event %s: %s", delivery.EventID, err)
+			}
+		}
+		delivery.Outcome = database.WebhookFailed
 		if errors.Is(err, database.ErrNotExist) {
-			respondWithError(w, http.StatusNotFound, "Couldnt find user")
+			return http.StatusNotFound, "Couldnt find user"
+		}
+		return http.StatusInternalServerError, "Couldnt update subscription"
+	}
+	delivery.Outcome = database.WebhookProcessed
+	return http.StatusOK, ""
+}
+
+// applyPolkaEvent moves the users subscription along. Without a period end
+// in the payload a new billing period starts now.
+func (cfg *apiConfig) applyPolkaEvent(event string, userID int, periodEnd time.Time) error {
+	now := time.Now().UTC()
+	if periodEnd.IsZero() {
+		periodEnd = now.Add(cfg.Polka.BillingPeriod)
+	}
+	var err error
+	switch event {
+	case polkaEventUpgraded:
+		var user database.User
+		user, err = cfg.DB.UpgradeUser(userID, periodEnd)
+		if err == nil {
+			cfg.publishEvent(webhook.EventUserUpgraded, user.ID, newUser(user))
+		}
+	case polkaEventRenewed:
+		_, err = cfg.DB.RenewSubscription(userID, periodEnd)
+	case polkaEventCanceled:
+		_, err = cfg.DB.CancelSubscription(userID)
+	case polkaEventPaymentFailed:
+		_, err = cfg.DB.MarkPaymentFailed(userID, now.Add(cfg.Polka.GracePeriod))
+	case polkaEventDowngraded:
+		_, err = cfg.DB.DowngradeUser(userID)
+	}
+	if err == nil {
+		cfg.notify(database.Notification{
+			UserID: userID,
+			Type:   database.NotificationSubscription,
+			Detail: event,
+		})
+	}
+	return err
+}
+
+// expireSubscriptions is run periodically to end memberships that were
+// neither renewed nor paid in time.
+func (cfg *apiConfig) expireSubscriptions() error {
+	expired, err := cfg.DB.ExpireLapsedSubscriptions(time.Now().UTC(), cfg.Polka.GracePeriod)
+	if err != nil {
+		return err
+	}
+	if len(expired) > 0 {
+		log.Printf("Expired Chirpy Red for users %v", expired)
+	}
+	for _, userID := range expired {
+		cfg.notify(database.Notification{
+			UserID: userID,
+			Type:   database.NotificationSubscription,
+			Detail: "subscription.expired",
+		})
+	}
+	return nil
+}
+
+// authenticatePolka requires a valid HMAC signature when a signing secret
+// is configured and falls back to the shared ApiKey otherwise. It returns
+// a zero code on success.
+func (cfg *apiConfig) authenticatePolka(r *http.Request, body []byte) (int, string) {
+	if cfg.Polka.SigningSecret != "" {
+		err := auth.VerifyWebhookSignature(
+			r.Header.Get("X-Polka-Signature"),
+			body,
+			cfg.Polka.SigningSecret,
+			cfg.Polka.SignatureTolerance,
+			time.Now(),
+		)
+		if err != nil {
+			return http.StatusUnauthorized, "Invalid signature"
+		}
+		return 0, ""
+	}
+
+	apiKey, err := auth.GetBearerToken(r.Header, "ApiKey")
+	if err != nil {
+		return http.StatusUnauthorized, "No ApiKey found"
+	}
+	if cfg.ApiKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.ApiKey)) != 1 {
+		return http.StatusUnauthorized, "Invalid Request"
+	}
+	return 0, ""
+}
+
+func (cfg *apiConfig) handleAdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Couldnt parse limit")
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, "Couldnt upgrade user")
+		limit = parsed
+	}
+	deliveries, err := cfg.DB.GetWebhookDeliveries(r.URL.Query().Get("source"), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve deliveries")
 		return
 	}
-	respondWithJson(w, http.StatusOK, struct{}{})
+	respondWithJson(w, http.StatusOK, deliveries)
 }
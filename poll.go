@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 4
+	maxPollOptionLength = 50
+	minPollDuration     = 5 * time.Minute
+	maxPollDuration     = 7 * 24 * time.Hour
+)
+
+// validPoll writes a 400 and returns nil unless the poll has 2 to 4
+// distinct options and closes between minPollDuration and maxPollDuration
+// after opensAt. The options are returned trimmed.
+func validPoll(w http.ResponseWriter, poll database.Poll, opensAt time.Time) *database.Poll {
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		respondWithError(w, http.StatusBadRequest, "A poll needs 2 to 4 options")
+		return nil
+	}
+	options := make([]string, 0, len(poll.Options))
+	seen := map[string]struct{}{}
+	for _, option := range poll.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || len(option) > maxPollOptionLength {
+			respondWithError(w, http.StatusBadRequest, "Poll options must be 1 to 50 characters long")
+			return nil
+		}
+		if _, ok := seen[strings.ToLower(option)]; ok {
+			respondWithError(w, http.StatusBadRequest, "Poll options must be different")
+			return nil
+		}
+		seen[strings.ToLower(option)] = struct{}{}
+		options = append(options, option)
+	}
+	if poll.ClosesAt.Before(opensAt.Add(minPollDuration)) {
+		respondWithError(w, http.StatusBadRequest, "A poll must stay open for at least 5 minutes")
+		return nil
+	}
+	if poll.ClosesAt.After(opensAt.Add(maxPollDuration)) {
+		respondWithError(w, http.StatusBadRequest, "A poll cant stay open for more than 7 days")
+		return nil
+	}
+	return &database.Poll{
+		Options:  options,
+		ClosesAt: poll.ClosesAt.UTC(),
+	}
+}
+
+func (cfg *apiConfig) handlePollRetrieve(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	viewerID, ok := cfg.viewer(w, r)
+	if !ok {
+		return
+	}
+	results, err := cfg.DB.GetPollResults(chirpID, viewerID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find poll")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve poll")
+		return
+	}
+	respondWithJson(w, http.StatusOK, results)
+}
+
+func (cfg *apiConfig) handlePollVote(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Option *int `json:"option"`
+	}
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.Option == nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	results, err := cfg.DB.VotePoll(chirpID, userID, *params.Option)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotExist):
+			respondWithError(w, http.StatusNotFound, "Couldnt find poll option")
+		case errors.Is(err, database.ErrAlreadyVoted):
+			respondWithError(w, http.StatusConflict, "You already voted")
+		case errors.Is(err, database.ErrPollClosed):
+			respondWithError(w, http.StatusForbidden, "Poll is closed")
+		case errors.Is(err, database.ErrBlocked):
+			respondWithError(w, http.StatusForbidden, "You cant vote in polls of a user who blocked you")
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldnt vote")
+		}
+		return
+	}
+	respondWithJson(w, http.StatusOK, results)
+}
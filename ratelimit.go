@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// userRateLimiter counts requests per user in fixed one minute windows.
+// The limit is passed per call since it depends on the users plan.
+type userRateLimiter struct {
+	mu      sync.Mutex
+	windows map[int]rateWindow
+}
+
+func newUserRateLimiter() *userRateLimiter {
+	return &userRateLimiter{
+		windows: map[int]rateWindow{},
+	}
+}
+
+// Allow records a request and returns how long to wait if it is over
+// perMinute. A limit of zero or less means unlimited.
+func (l *userRateLimiter) Allow(userID, perMinute int) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	window := l.windows[userID]
+	if now.Sub(window.start) >= time.Minute {
+		window = rateWindow{start: now}
+		for id, stale := range l.windows {
+			if now.Sub(stale.start) >= time.Minute {
+				delete(l.windows, id)
+			}
+		}
+	}
+	if window.count >= perMinute {
+		return false, window.start.Add(time.Minute).Sub(now)
+	}
+	window.count++
+	l.windows[userID] = window
+	return true, 0
+}
+
+func respondWithRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// validPublishAt writes a 400 and returns false unless publishAt is in the
+// future and no further than maxScheduleAhead.
+func validPublishAt(w http.ResponseWriter, publishAt time.Time) bool {
+	now := time.Now()
+	if !publishAt.After(now) {
+		respondWithError(w, http.StatusBadRequest, "publish_at must be in the future")
+		return false
+	}
+	if publishAt.After(now.Add(maxScheduleAhead)) {
+		respondWithError(w, http.StatusBadRequest, "publish_at is too far in the future")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handleScheduledChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+	chirps, err := cfg.DB.GetScheduledChirps(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt retrieve scheduled chirps")
+		return
+	}
+	respondWithJson(w, http.StatusOK, chirps)
+}
+
+func respondWithScheduleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldnt find scheduled chirp")
+		return
+	}
+	if errors.Is(err, database.ErrJobRunning) {
+		respondWithError(w, http.StatusConflict, "Chirp is being published")
+		return
+	}
+	if errors.Is(err, database.ErrPollClosed) {
+		respondWithError(w, http.StatusBadRequest, "The chirps poll would close before it is published")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldnt update scheduled chirp")
+}
+
+func (cfg *apiConfig) handleScheduledChirpReschedule(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PublishAt time.Time `json:"publish_at"`
+	}
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	if !validPublishAt(w, params.PublishAt) {
+		return
+	}
+	chirp, err := cfg.DB.RescheduleChirp(userID, chirpID, params.PublishAt)
+	if err != nil {
+		respondWithScheduleError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, chirp)
+}
+
+func (cfg *apiConfig) handleScheduledChirpCancel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorize(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse id")
+		return
+	}
+	err = cfg.DB.CancelScheduledChirp(userID, chirpID)
+	if err != nil {
+		respondWithScheduleError(w, err)
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
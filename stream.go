@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/events"
+)
+
+const (
+	streamHeartbeat    = 15 * time.Second
+	streamBuffer       = 64
+	streamWriteTimeout = 10 * time.Second
+)
+
+// chirpStreamFilter limits a stream to some authors. A nil set means every
+// author. Authors the viewer blocked or muted are always left out.
+type chirpStreamFilter struct {
+	authors  map[int]struct{}
+	hidden   map[int]struct{}
+	explicit []int
+	followed bool
+	viewerID int
+}
+
+func (f *chirpStreamFilter) refresh(db *database.DB) error {
+	hidden, err := db.GetHiddenAuthors(f.viewerID)
+	if err != nil {
+		return err
+	}
+	f.hidden = hidden
+	if f.explicit == nil && !f.followed {
+		return nil
+	}
+	authors := map[int]struct{}{}
+	for _, id := range f.explicit {
+		authors[id] = struct{}{}
+	}
+	if f.followed {
+		followees, err := db.GetFollowees(f.viewerID)
+		if err != nil {
+			return err
+		}
+		for _, id := range followees {
+			authors[id] = struct{}{}
+		}
+	}
+	f.authors = authors
+	return nil
+}
+
+func (f *chirpStreamFilter) matches(event events.Event) bool {
+	if event.Recipient != 0 {
+		return false
+	}
+	chirp, ok := event.Data.(database.Chirp)
+	if !ok {
+		return false
+	}
+	if _, ok := f.hidden[chirp.AuthorID]; ok {
+		return false
+	}
+	if f.authors == nil {
+		return true
+	}
+	_, ok = f.authors[chirp.AuthorID]
+	return ok
+}
+
+func parseAuthorIDs(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	ids := []int{}
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// handleChirpsStream pushes chirp.created and chirp.deleted events as
+// Server-Sent Events. Clients resume with the Last-Event-ID header; slow
+// clients are disconnected and expected to resume the same way.
+func (cfg *apiConfig) handleChirpsStream(w http.ResponseWriter, r *http.Request) {
+	filter := &chirpStreamFilter{}
+	authorIDs, err := parseAuthorIDs(r.URL.Query().Get("author_id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt parse author id")
+		return
+	}
+	filter.explicit = authorIDs
+	viewerID, ok := cfg.viewer(w, r)
+	if !ok {
+		return
+	}
+	filter.viewerID = viewerID
+	if r.URL.Query().Get("followed") == "true" {
+		if viewerID == 0 {
+			respondWithError(w, http.StatusUnauthorized, "Couldnt find jwt")
+			return
+		}
+		filter.followed = true
+	}
+	if err := filter.refresh(cfg.DB); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt load stream filter")
+		return
+	}
+
+	lastEventID := int64(0)
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		lastEventID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldnt parse Last-Event-ID")
+			return
+		}
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Subscribe before replaying so nothing published in between is lost.
+	sub := cfg.Events.Subscribe(streamBuffer)
+	defer sub.Close()
+
+	send := func(format string, args ...interface{}) bool {
+		controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return controller.Flush() == nil
+	}
+	sendEvent := func(event events.Event) bool {
+		if event.ID <= lastEventID {
+			return true
+		}
+		lastEventID = event.ID
+		if !filter.matches(event) {
+			return true
+		}
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return true
+		}
+		return send("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Topic, data)
+	}
+
+	if !send("retry: 3000\n\n") {
+		return
+	}
+	if lastEventID > 0 {
+		missed, complete := cfg.Events.Since(lastEventID)
+		if !complete {
+			if !send("event: resync\ndata: {}\n\n") {
+				return
+			}
+			// The id may be from before a restart, in which case new
+			// events have smaller ids and would otherwise be skipped.
+			lastEventID = 0
+		}
+		for _, event := range missed {
+			if !sendEvent(event) {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects with
+				// Last-Event-ID and catches up from the history.
+				return
+			}
+			if !sendEvent(event) {
+				return
+			}
+		case <-heartbeat.C:
+			if err := filter.refresh(cfg.DB); err != nil {
+				return
+			}
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handleSubscriptionRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	subscription := user.Subscription
+	subscription.Plan = user.UserPlan()
+	if subscription.Status == "" {
+		subscription.Status = database.SubscriptionNone
+		if user.IsChirpyRed {
+			subscription.Status = database.SubscriptionActive
+		}
+	}
+	if subscription.History == nil {
+		subscription.History = []database.SubscriptionEvent{}
+	}
+	respondWithJson(w, http.StatusOK, subscription)
+}
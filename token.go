@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/thorbenbender/chirpy/internal/auth"
 )
@@ -27,11 +28,26 @@ func (cfg *apiConfig) HandleTokenRefresh(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	accessToken, err := auth.RefreshToken(refreshToken, cfg.JWTSecret)
+	userID, sessionID, err := auth.ValidateRefreshToken(refreshToken, cfg.JWTSecret)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldnt validate JWT")
 		return
 	}
+	if sessionID != "" {
+		if err := cfg.DB.TouchSession(userID, sessionID); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Session has ended")
+			return
+		}
+	}
+	if !cfg.checkAccount(w, userID) {
+		return
+	}
+
+	accessToken, err := auth.MakeJWT(userID, cfg.JWTSecret, time.Hour, auth.TokenTypeAccess)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt create access JWT")
+		return
+	}
 	respondWithJson(w, http.StatusOK, refreshResponse{
 		Token: accessToken,
 	})
@@ -48,6 +64,13 @@ func (cfg *apiConfig) HandleTokenRevoke(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldnt revoke token")
 		return
 	}
+	if _, sessionID, err := auth.ValidateRefreshToken(refreshToken, cfg.JWTSecret); err == nil && sessionID != "" {
+		err = cfg.DB.DeleteSession(sessionID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldnt end session")
+			return
+		}
+	}
 
 	respondWithJson(w, http.StatusOK, struct{}{})
 }
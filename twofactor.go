@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, user database.User) {
+	token, err := auth.MakeJWT(user.ID, cfg.JWTSecret, mfaChallengeTTL, auth.TokenTypeMFAChallenge)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt create MFA challenge")
+		return
+	}
+	respondWithJson(w, http.StatusOK, mfaChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code
+// and consumes it so it cant be used again.
+func (cfg *apiConfig) checkSecondFactor(user database.User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		return cfg.DB.UseRecoveryCode(user.ID, auth.HashRecoveryCode(recoveryCode))
+	}
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return errors.New("invalid code")
+	}
+	return cfg.DB.UseTOTPStep(user.ID, step)
+}
+
+func (cfg *apiConfig) handle2FASetup(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	if user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt generate secret")
+		return
+	}
+	err = cfg.DB.SetPendingTOTP(user.ID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt store secret")
+		return
+	}
+	respondWithJson(w, http.StatusOK, response{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI(cfg.Account.TOTPIssuer, user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handle2FAEnable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	if user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		respondWithError(w, http.StatusBadRequest, "Call /api/2fa/setup first")
+		return
+	}
+	step, ok := auth.ValidateTOTP(user.TOTPPendingSecret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt generate recovery codes")
+		return
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+	_, err = cfg.DB.EnableTOTP(user.ID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt enable two-factor authentication")
+		return
+	}
+	respondWithJson(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+// handle2FADisable needs the password as well as a code, so a stolen
+// access token alone cant strip the second factor.
+func (cfg *apiConfig) handle2FADisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldnt find user")
+		return
+	}
+	if !user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+	if !cfg.confirmPassword(w, r, user, params.Password, params.Code, params.RecoveryCode) {
+		return
+	}
+	user, err = cfg.DB.DisableTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt disable two-factor authentication")
+		return
+	}
+	respondWithJson(w, http.StatusOK, newUser(user))
+}
+
+// handle2FAVerify exchanges an MFA challenge token from handleUserLogin and
+// a valid code for the usual access/refresh pair.
+func (cfg *apiConfig) handle2FAVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	subject, err := auth.ValidateTokenType(params.MFAToken, cfg.JWTSecret, auth.TokenTypeMFAChallenge)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt parse id")
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt find user")
+		return
+	}
+
+	accountKey := accountAttemptKey(user.Email)
+	ipKey := ipAttemptKey(clientIP(r))
+	wait, err := cfg.LoginLimiter.Attempt(accountKey, ipKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait)
+		return
+	}
+	err = cfg.checkSecondFactor(user, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err := cfg.LoginLimiter.Succeeded(accountKey, ipKey); err != nil {
+		log.Printf("Couldnt reset failed logins: %s", err)
+	}
+	cfg.respondWithSession(w, r, user)
+}
@@ -2,17 +2,40 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
+	netmail "net/mail"
 	"time"
 
 	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
 )
 
 type User struct {
 	Email       string `json:"email"`
 	ID          int    `json:"id"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	IsVerified  bool   `json:"is_verified"`
+	Role        string `json:"role"`
+	// PendingEmail is the email waiting to be confirmed, only shown to
+	// the user themselves.
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+func newUser(user database.User) User {
+	return User{
+		Email:       user.Email,
+		ID:          user.ID,
+		IsChirpyRed: user.IsChirpyRed,
+		IsVerified:  user.IsVerified,
+		Role:        string(user.UserRole()),
+	}
+}
+
+type policyErrorResponse struct {
+	Error      string                 `json:"error"`
+	Violations []auth.PolicyViolation `json:"violations"`
 }
 
 type authResponse struct {
@@ -33,8 +56,16 @@ func (cfg *apiConfig) handleUserCreate(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Could not decode parameters")
 		return
 	}
+	address, err := netmail.ParseAddress(params.Email)
+	if err != nil || address.Address != params.Email {
+		respondWithError(w, http.StatusBadRequest, "Invalid email")
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password, params.Email) {
+		return
+	}
 
-	encryptedPassword, err := auth.HashPassword(params.Password)
+	encryptedPassword, err := cfg.Passwords.Hash(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
@@ -45,11 +76,10 @@ func (cfg *apiConfig) handleUserCreate(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Could not create user")
 		return
 	}
-	respondWithJson(w, http.StatusCreated, User{
-		Email:       user.Email,
-		ID:          user.ID,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	if err := cfg.sendVerificationEmail(user); err != nil {
+		log.Printf("Couldnt send verification email: %s", err)
+	}
+	respondWithJson(w, http.StatusCreated, newUser(user))
 }
 
 func (cfg *apiConfig) handleUserLogin(w http.ResponseWriter, r *http.Request) {
@@ -62,82 +92,262 @@ func (cfg *apiConfig) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldnt decode parameters")
+		return
 	}
-	user, err := cfg.DB.GetUserByEmail(params.Email)
+
+	accountKey := accountAttemptKey(params.Email)
+	ipKey := ipAttemptKey(clientIP(r))
+	wait, err := cfg.LoginLimiter.Attempt(accountKey, ipKey)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if err == nil {
+		err = cfg.Passwords.Verify(params.Password, user.Password)
 	}
-	err = auth.CheckPassword(params.Password, user.Password)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Wrong password")
+		respondWithError(w, http.StatusUnauthorized, "Wrong email or password")
+		return
+	}
+	if err := cfg.LoginLimiter.Succeeded(accountKey, ipKey); err != nil {
+		log.Printf("Couldnt reset failed logins: %s", err)
+	}
+	if !user.IsVerified && !cfg.Account.UnverifiedLogin {
+		respondWithError(w, http.StatusForbidden, "Email is not verified")
+		return
 	}
+	if cfg.Passwords.NeedsRehash(user.Password) {
+		cfg.rehashPassword(user.ID, params.Password)
+	}
+
+	if user.TOTPEnabled {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+	cfg.respondWithSession(w, r, user)
+}
 
+// respondWithSession starts a session for user and issues a fresh
+// access/refresh token pair for it.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	if restriction := accountRestriction(user); restriction != "" {
+		respondWithError(w, http.StatusForbidden, restriction)
+		return
+	}
 	accessToken, err := auth.MakeJWT(user.ID, cfg.JWTSecret, time.Hour, auth.TokenTypeAccess)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldnt create access JWT")
+		return
 	}
 
-	refreshToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.JWTSecret,
-		time.Hour*24*30*6,
-		auth.TokenTypeRefresh,
-	)
+	sessionID, err := auth.GenerateSessionID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt start session")
+		return
+	}
+	refreshTTL := time.Hour * 24 * 30 * 6
+	session, err := cfg.DB.CreateSession(database.Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		ExpiresAt: time.Now().UTC().Add(refreshTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt start session")
+		return
+	}
+	refreshToken, err := auth.MakeRefreshToken(user.ID, session.ID, cfg.JWTSecret, refreshTTL)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldnt create refresh JWT")
+		return
 	}
 
 	respondWithJson(w, http.StatusOK, authResponse{
-		User: User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User:         newUser(user),
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	})
 }
 
-func (cfg *apiConfig) handlerUserUpdate(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+// checkPasswordPolicy writes a 422 listing every failed rule and returns
+// false when the password is not acceptable.
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, password, email string) bool {
+	violations, err := cfg.PasswordPolicy.Check(password, email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt check password")
+		return false
 	}
-	token, err := auth.GetBearerToken(r.Header, "Bearer")
+	if len(violations) > 0 {
+		respondWithJson(w, http.StatusUnprocessableEntity, policyErrorResponse{
+			Error:      "Password does not meet the password policy",
+			Violations: violations,
+		})
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) rehashPassword(userID int, password string) {
+	hashedPassword, err := cfg.Passwords.Hash(password)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldnt find jwt")
+		log.Printf("Couldnt rehash password for user %d: %s", userID, err)
 		return
 	}
-	subject, err := auth.ValidateJWT(token, cfg.JWTSecret)
+	err = cfg.DB.UpdateUserPassword(userID, hashedPassword)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldnt validate JWT")
+		log.Printf("Couldnt store rehashed password for user %d: %s", userID, err)
+	}
+}
+
+// newOwnUser is newUser for the user themselves.
+func newOwnUser(user database.User) User {
+	response := newUser(user)
+	response.PendingEmail = user.PendingEmail
+	return response
+}
+
+// handlerUserUpdate only changes the fields that are sent. Changing the
+// password or email needs the current password, and second factor if the
+// user has one, so a stolen access token alone cant take the account over;
+// personal access tokens cant change them at all. A new email takes effect
+// once it is confirmed through handleEmailChangeConfirm.
+func (cfg *apiConfig) handlerUserUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		Code            string  `json:"code"`
+		RecoveryCode    string  `json:"recovery_code"`
+	}
+	userIDInt, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldnt decode parameters")
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
 	}
-	hashedPassword, err := auth.HashPassword(params.Password)
+	user, err := cfg.DB.GetUser(userIDInt)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldnt hash password")
+		respondWithError(w, http.StatusUnauthorized, "Couldnt find user")
 		return
 	}
-	userIDInt, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldnt parse id")
+	changeEmail := params.Email != nil && *params.Email != user.Email
+	changePassword := params.Password != nil
+	if !changeEmail && !changePassword {
+		respondWithJson(w, http.StatusOK, newOwnUser(user))
+		return
+	}
+
+	// Everything is validated before anything is changed, so a request
+	// either applies completely or not at all.
+	email := user.Email
+	if changeEmail {
+		address, err := netmail.ParseAddress(*params.Email)
+		if err != nil || address.Address != *params.Email {
+			respondWithError(w, http.StatusBadRequest, "Invalid email")
+			return
+		}
+		email = *params.Email
+	}
+	if changePassword && !cfg.checkPasswordPolicy(w, *params.Password, email) {
+		return
+	}
+	if !cfg.confirmPassword(w, r, user, params.CurrentPassword, params.Code, params.RecoveryCode) {
 		return
 	}
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
+	if changeEmail {
+		taken, err := cfg.DB.DoesUserExist(*params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldnt check email")
+			return
+		}
+		if taken {
+			respondWithError(w, http.StatusConflict, "Email is already taken")
+			return
+		}
+	}
+
+	change := database.CredentialChange{}
+	if changePassword {
+		change.Password, err = cfg.Passwords.Hash(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldnt hash password")
+			return
+		}
+	}
+	emailToken := ""
+	if changeEmail {
+		emailToken, err = auth.MakeActionToken(user.ID, cfg.JWTSecret, cfg.Account.EmailChangeTokenTTL, auth.TokenTypeChangeEmail)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldnt create email change token")
+			return
+		}
+		actionToken, err := auth.ValidateActionToken(emailToken, cfg.JWTSecret, auth.TokenTypeChangeEmail)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldnt create email change token")
+			return
+		}
+		change.PendingEmail = *params.Email
+		change.PendingEmailTokenID = actionToken.ID
+	}
+	user, err = cfg.DB.UpdateCredentials(user.ID, change)
 	if err != nil {
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Email is already taken")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldnt update user")
 		return
 	}
-	respondWithJson(w, http.StatusOK, User{
-		ID:          user.ID,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	if changeEmail {
+		if err := cfg.sendEmailChangeEmails(user, emailToken); err != nil {
+			log.Printf("Couldnt send email change emails: %s", err)
+		}
+	}
+	respondWithJson(w, http.StatusOK, newOwnUser(user))
+}
+
+// handleEmailChangeConfirm makes the pending email of the tokens user their
+// email. Only the token from the latest change request works.
+func (cfg *apiConfig) handleEmailChangeConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	actionToken, ok := cfg.consumeActionToken(w, params.Token, auth.TokenTypeChangeEmail)
+	if !ok {
+		return
+	}
+	user, err := cfg.DB.ConfirmEmailChange(actionToken.UserID, actionToken.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+			return
+		}
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Email is already taken")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt change email")
+		return
+	}
+	respondWithJson(w, http.StatusOK, newOwnUser(user))
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/webhook"
+)
+
+// handlePasswordConfirm checks the users password, and second factor if
+// they have one, and returns a short-lived token that dangerous actions
+// such as deleting the account require. A stolen access token alone is not
+// enough for them.
+func (cfg *apiConfig) handlePasswordConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	type response struct {
+		ConfirmationToken string    `json:"confirmation_token"`
+		ExpiresAt         time.Time `json:"expires_at"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldnt find user")
+		return
+	}
+	if !cfg.confirmPassword(w, r, user, params.Password, params.Code, params.RecoveryCode) {
+		return
+	}
+
+	token, err := auth.MakeActionToken(user.ID, cfg.JWTSecret, cfg.Account.PasswordConfirmationTTL, auth.TokenTypePasswordConfirmation)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldnt create confirmation token")
+		return
+	}
+	respondWithJson(w, http.StatusOK, response{
+		ConfirmationToken: token,
+		ExpiresAt:         time.Now().UTC().Add(cfg.Account.PasswordConfirmationTTL),
+	})
+}
+
+// handleUserDelete deletes the signed in user. It needs a confirmation
+// token from handlePasswordConfirm for the same user, and ends all their
+// sessions and tokens.
+func (cfg *apiConfig) handleUserDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ConfirmationToken string `json:"confirmation_token"`
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldnt decode parameters")
+		return
+	}
+	confirmation, ok := cfg.consumeActionToken(w, params.ConfirmationToken, auth.TokenTypePasswordConfirmation)
+	if !ok {
+		return
+	}
+	if confirmation.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Confirm your password first")
+		return
+	}
+	if _, ok := cfg.deleteUser(w, userID); !ok {
+		return
+	}
+	respondWithJson(w, http.StatusOK, struct{}{})
+}
+
+// deleteUser deletes the user and cleans up after them outside the
+// database. It writes the error response and returns false if that fails.
+func (cfg *apiConfig) deleteUser(w http.ResponseWriter, userID int) (database.DeletedUser, bool) {
+	deleted, err := cfg.DB.DeleteUser(userID, cfg.Account.AnonymizeDeletedChirps)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldnt find user")
+			return database.DeletedUser{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldnt delete user")
+		return database.DeletedUser{}, false
+	}
+	for _, chirp := range deleted.Chirps {
+		cfg.publishEvent(webhook.EventChirpDeleted, chirp.AuthorID, chirp)
+	}
+	for _, file := range deleted.ExportFiles {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldnt remove export %s: %s", file, err)
+		}
+	}
+	if err := cfg.LoginLimiter.Reset(accountAttemptKey(deleted.User.Email)); err != nil {
+		log.Printf("Couldnt reset failed logins: %s", err)
+	}
+	return deleted, true
+}
@@ -6,10 +6,7 @@ import (
 )
 
 
-func validate_chirp(body string) (string, error) {
-    
-
-  const maxChirpLength = 140
+func validate_chirp(body string, maxChirpLength int) (string, error) {
   if len(body) > maxChirpLength {
     return "", errors.New("Chirp is too long")
   }
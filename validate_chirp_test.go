@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateChirp(t *testing.T) {
+	cases := []struct {
+		input    string
+		max      int
+		expected string
+		wantErr  bool
+	}{
+		{
+			input:    "This is a kerfuffle",
+			max:      140,
+			expected: "This is a ****",
+		},
+		{
+			input:    "Sharbert and FORNAX are banned in any case",
+			max:      140,
+			expected: "**** and **** are banned in any case",
+		},
+		{
+			input:    "Punctuation keeps sharbert! as it is",
+			max:      140,
+			expected: "Punctuation keeps sharbert! as it is",
+		},
+		{
+			input:    strings.Repeat("a", 140),
+			max:      140,
+			expected: strings.Repeat("a", 140),
+		},
+		{
+			input:   strings.Repeat("a", 141),
+			max:     140,
+			wantErr: true,
+		},
+		{
+			input:    strings.Repeat("a", 141),
+			max:      280,
+			expected: strings.Repeat("a", 141),
+		},
+	}
+
+	for _, cas := range cases {
+		actual, err := validate_chirp(cas.input, cas.max)
+		if cas.wantErr {
+			if err == nil {
+				t.Errorf("Chirp of length %d should be too long for %d", len(cas.input), cas.max)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error: %s", err.Error())
+		}
+		if actual != cas.expected {
+			t.Errorf("String should be %s not %s", cas.expected, actual)
+		}
+	}
+}
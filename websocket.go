@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/thorbenbender/chirpy/internal/auth"
+	"github.com/thorbenbender/chirpy/internal/database"
+	"github.com/thorbenbender/chirpy/internal/events"
+)
+
+const (
+	wsPingInterval   = 30 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsWriteTimeout   = 10 * time.Second
+	wsCloseWait      = time.Second
+	wsAuthWait       = 10 * time.Second
+	wsSendBuffer     = 64
+	wsMaxMessageSize = 4096
+
+	// wsCloseTokenExpired tells the client to refresh its access token and
+	// reconnect.
+	wsCloseTokenExpired = 4001
+
+	wsChannelGlobal        = "global"
+	wsChannelAuthor        = "author"
+	wsChannelNotifications = "notifications"
+	wsChannelMessages      = "messages"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// checkWebsocketOrigin only lets browsers connect from Chirpy itself.
+// Clients that send no Origin are not browsers and cant be tricked into
+// connecting by another site.
+func (cfg *apiConfig) checkWebsocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	allowed, err := url.Parse(cfg.Account.BaseURL)
+	if err != nil {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Scheme, allowed.Scheme) && strings.EqualFold(parsed.Host, allowed.Host)
+}
+
+type wsClientMessage struct {
+	Type     string `json:"type"`
+	Channel  string `json:"channel"`
+	AuthorID int    `json:"author_id"`
+	Token    string `json:"token"`
+}
+
+type wsServerMessage struct {
+	Type     string        `json:"type"`
+	Channel  string        `json:"channel,omitempty"`
+	AuthorID int           `json:"author_id,omitempty"`
+	Event    *events.Event `json:"event,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// wsSubscriptions is the set of channels a connection listens to. The read
+// loop changes it while the write loop matches events against it.
+type wsSubscriptions struct {
+	mu            sync.Mutex
+	global        bool
+	authors       map[int]struct{}
+	notifications bool
+	messages      bool
+	// hidden are the authors the user blocked or muted.
+	hidden map[int]struct{}
+}
+
+var errAccountRestricted = errors.New("account is restricted")
+
+// refresh returns ErrNotExist once the user was deleted and
+// errAccountRestricted while they are locked out.
+func (s *wsSubscriptions) refresh(db *database.DB, userID int) error {
+	user, err := db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if accountRestriction(user) != "" {
+		return errAccountRestricted
+	}
+	hidden, err := db.GetHiddenAuthors(userID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.hidden = hidden
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *wsSubscriptions) set(msg wsClientMessage, on bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch msg.Channel {
+	case wsChannelGlobal:
+		s.global = on
+	case wsChannelNotifications:
+		s.notifications = on
+	case wsChannelMessages:
+		s.messages = on
+	case wsChannelAuthor:
+		if msg.AuthorID <= 0 {
+			return false
+		}
+		if on {
+			s.authors[msg.AuthorID] = struct{}{}
+		} else {
+			delete(s.authors, msg.AuthorID)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// match returns the channel event should be delivered on, if any.
+func (s *wsSubscriptions) match(event events.Event, userID int) (wsServerMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if event.Recipient != 0 {
+		if event.Recipient != userID {
+			return wsServerMessage{}, false
+		}
+		switch event.Topic {
+		case database.TopicMessageCreated, database.TopicConversationRead:
+			if s.messages {
+				return wsServerMessage{Type: "event", Channel: wsChannelMessages, Event: &event}, true
+			}
+		default:
+			if s.notifications {
+				return wsServerMessage{Type: "event", Channel: wsChannelNotifications, Event: &event}, true
+			}
+		}
+		return wsServerMessage{}, false
+	}
+	chirp, ok := event.Data.(database.Chirp)
+	if !ok {
+		return wsServerMessage{}, false
+	}
+	if _, ok := s.hidden[chirp.AuthorID]; ok {
+		return wsServerMessage{}, false
+	}
+	if s.global {
+		return wsServerMessage{Type: "event", Channel: wsChannelGlobal, Event: &event}, true
+	}
+	if _, ok := s.authors[chirp.AuthorID]; ok {
+		return wsServerMessage{Type: "event", Channel: wsChannelAuthor, AuthorID: chirp.AuthorID, Event: &event}, true
+	}
+	return wsServerMessage{}, false
+}
+
+type wsClient struct {
+	cfg    *apiConfig
+	conn   *websocket.Conn
+	userID int
+	subs   wsSubscriptions
+	// send buffers replies from the read loop for the write loop, which is
+	// the only goroutine allowed to write to conn.
+	send   chan wsServerMessage
+	reauth chan time.Time
+	done   chan struct{}
+
+	// closeCode and closeText are set by the read loop before it closes
+	// done.
+	closeCode int
+	closeText string
+}
+
+// handleWebsocket multiplexes the global feed, author feeds and the user's
+// own notifications and direct messages over one connection. It
+// authenticates with the access JWT in the Authorization header or, for
+// browsers, which cant set headers, an auth message sent first. Tokens are
+// never taken from the URL, where they would end up in access logs. It
+// closes with code 4001 when the token expires unless the client sends a
+// reauth message with a fresh one.
+func (cfg *apiConfig) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	client := &wsClient{
+		cfg:    cfg,
+		subs:   wsSubscriptions{authors: map[int]struct{}{}},
+		send:   make(chan wsServerMessage, wsSendBuffer),
+		reauth: make(chan time.Time, 1),
+		done:   make(chan struct{}),
+	}
+	var expiresAt time.Time
+	token, err := auth.GetBearerToken(r.Header, "Bearer")
+	if err == nil {
+		var code int
+		var message string
+		client.userID, expiresAt, code, message = client.authenticate(token)
+		if code != 0 {
+			respondWithError(w, code, message)
+			return
+		}
+	}
+
+	upgrader := wsUpgrader
+	upgrader.CheckOrigin = cfg.checkWebsocketOrigin
+	client.conn, err = upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response.
+		return
+	}
+	if client.userID == 0 {
+		var ok bool
+		expiresAt, ok = client.authenticateFirstMessage()
+		if !ok {
+			client.conn.Close()
+			return
+		}
+	}
+	sub := cfg.Events.Subscribe(wsSendBuffer)
+	defer sub.Close()
+
+	go client.readLoop()
+	client.writeLoop(sub, expiresAt)
+}
+
+// authenticate validates an access JWT and loads the users filters. If
+// that fails it returns the status code and message to reject it with.
+func (c *wsClient) authenticate(token string) (userID int, expiresAt time.Time, code int, message string) {
+	userID, expiresAt, err := auth.ValidateAccessToken(token, c.cfg.JWTSecret)
+	if err != nil {
+		return 0, time.Time{}, http.StatusUnauthorized, "Couldnt validate JWT"
+	}
+	if err := c.subs.refresh(c.cfg.DB, userID); err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			return 0, time.Time{}, http.StatusUnauthorized, "User no longer exists"
+		}
+		if errors.Is(err, errAccountRestricted) {
+			return 0, time.Time{}, http.StatusForbidden, "Account is restricted"
+		}
+		return 0, time.Time{}, http.StatusInternalServerError, "Couldnt load blocked users"
+	}
+	return userID, expiresAt, 0, ""
+}
+
+// authenticateFirstMessage waits for the auth message of a client that
+// connected without an Authorization header, and closes the connection
+// with code 4001 if none with a valid token arrives in time.
+func (c *wsClient) authenticateFirstMessage() (time.Time, bool) {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsAuthWait))
+	msg := wsClientMessage{}
+	if err := c.conn.ReadJSON(&msg); err != nil || msg.Type != "auth" {
+		c.rejectAuth("auth message expected")
+		return time.Time{}, false
+	}
+	userID, expiresAt, code, message := c.authenticate(msg.Token)
+	if code != 0 {
+		c.rejectAuth(message)
+		return time.Time{}, false
+	}
+	c.userID = userID
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.conn.WriteJSON(wsServerMessage{Type: "authenticated"}); err != nil {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+func (c *wsClient) rejectAuth(text string) {
+	message := websocket.FormatCloseMessage(wsCloseTokenExpired, text)
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+}
+
+func (c *wsClient) readLoop() {
+	defer close(c.done)
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg := wsClientMessage{}
+		var reply wsServerMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			reply = wsServerMessage{Type: "error", Error: "Couldnt decode message"}
+		} else {
+			reply = c.handle(msg)
+		}
+		select {
+		case c.send <- reply:
+		default:
+			c.closeCode = websocket.ClosePolicyViolation
+			c.closeText = "send buffer full"
+			return
+		}
+	}
+}
+
+func (c *wsClient) handle(msg wsClientMessage) wsServerMessage {
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		if !c.subs.set(msg, msg.Type == "subscribe") {
+			return wsServerMessage{Type: "error", Error: "Unknown channel"}
+		}
+		return wsServerMessage{Type: msg.Type + "d", Channel: msg.Channel, AuthorID: msg.AuthorID}
+	case "reauth":
+		userID, expiresAt, err := auth.ValidateAccessToken(msg.Token, c.cfg.JWTSecret)
+		if err != nil || userID != c.userID {
+			return wsServerMessage{Type: "error", Error: "Couldnt validate JWT"}
+		}
+		select {
+		case <-c.reauth:
+		default:
+		}
+		c.reauth <- expiresAt
+		return wsServerMessage{Type: "reauthenticated"}
+	default:
+		return wsServerMessage{Type: "error", Error: "Unknown message type"}
+	}
+}
+
+func (c *wsClient) writeLoop(sub *events.Subscription, expiresAt time.Time) {
+	defer c.conn.Close()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	expiry := time.NewTimer(time.Until(expiresAt))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			code, text := c.closeCode, c.closeText
+			if code == 0 {
+				code = websocket.CloseNormalClosure
+			}
+			c.close(code, text)
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped by the broker for falling behind.
+				c.close(websocket.CloseTryAgainLater, "send buffer full")
+				return
+			}
+			msg, ok := c.subs.match(event, c.userID)
+			if ok && !c.write(msg) {
+				return
+			}
+		case msg := <-c.send:
+			if !c.write(msg) {
+				return
+			}
+		case expiresAt := <-c.reauth:
+			if !expiry.Stop() {
+				select {
+				case <-expiry.C:
+				default:
+				}
+			}
+			expiry.Reset(time.Until(expiresAt))
+		case <-expiry.C:
+			c.close(wsCloseTokenExpired, "token expired")
+			return
+		case <-ping.C:
+			// Pick up blocks and mutes made since the last ping.
+			if err := c.subs.refresh(c.cfg.DB, c.userID); err != nil {
+				if errors.Is(err, database.ErrNotExist) {
+					c.close(wsCloseTokenExpired, "user was deleted")
+					return
+				}
+				if errors.Is(err, errAccountRestricted) {
+					c.close(websocket.ClosePolicyViolation, "account is restricted")
+					return
+				}
+				c.close(websocket.CloseInternalServerErr, "couldnt load filters")
+				return
+			}
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) write(msg wsServerMessage) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(msg) == nil
+}
+
+// close sends a close frame and gives the client a moment to answer it
+// before the connection is torn down.
+func (c *wsClient) close(code int, text string) {
+	message := websocket.FormatCloseMessage(code, text)
+	if err := c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout)); err != nil {
+		return
+	}
+	select {
+	case <-c.done:
+	case <-time.After(wsCloseWait):
+	}
+}